@@ -1,3 +1,7 @@
 module modela
 
 go 1.22.3
+
+require clock v0.0.0
+
+replace clock => ../clock
@@ -1,24 +1,181 @@
 package testpackage1
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"slices"
 	"testing"
 	"time"
 
 	"modela/timeoutmanagement"
+	"modela/timeoutmanagement/timeouttest"
 )
 
 var tm = timeoutmanagement.NewTimeoutManager()
 
+func TestMain(m *testing.M) {
+	os.Exit(timeouttest.RunAndReport(m, tm, ""))
+}
+
 func TestSlowFunction(t *testing.T) {
+	clk := timeoutmanagement.NewFakeClock(time.Now())
+	tm.SetClock(clk)
+	defer tm.SetClock(nil)
 	tm.RegisterTimeout(timeoutmanagement.TimeoutConfig{Name: "SlowFunction", Timeout: 10 * time.Second})
 
-	tm.ExecuteWithTimeout("SlowFunction", func() {
+	err := tm.ExecuteWithTimeout("SlowFunction", func(ctx context.Context) error {
 		// Simulate a slow operation
-		time.Sleep(5 * time.Second)
+		if err := clk.Sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
 		t.Log("Slow function completed")
+		return nil
 	})
+	if err != nil {
+		t.Errorf("SlowFunction failed: %v", err)
+	}
 
 	t.Run("Example", func(t *testing.T) {
 		t.Log("Running example")
 	})
 }
+
+func TestReport(t *testing.T) {
+	clk := timeoutmanagement.NewFakeClock(time.Now())
+	m := timeoutmanagement.NewTimeoutManager()
+	m.SetClock(clk)
+	m.SetNearMissThreshold(0.5)
+	m.RegisterTimeout(timeoutmanagement.TimeoutConfig{Name: "Fast", Timeout: time.Second})
+	m.RegisterTimeout(timeoutmanagement.TimeoutConfig{Name: "Close", Timeout: 100 * time.Millisecond})
+	m.RegisterTimeout(timeoutmanagement.TimeoutConfig{Name: "Slow", Timeout: 20 * time.Millisecond})
+	m.RegisterTimeout(timeoutmanagement.TimeoutConfig{Name: "Broken", Timeout: time.Second})
+
+	m.ExecuteWithTimeout("Fast", func(ctx context.Context) error { return nil })
+	m.ExecuteWithTimeout("Close", func(ctx context.Context) error { return clk.Sleep(ctx, 70*time.Millisecond) })
+	m.ExecuteWithTimeout("Slow", func(ctx context.Context) error { return clk.Sleep(ctx, 200*time.Millisecond) })
+	m.ExecuteWithTimeout("Broken", func(ctx context.Context) error { return errors.New("broken") })
+
+	want := map[string]timeoutmanagement.Outcome{
+		"Fast":   timeoutmanagement.OutcomePassed,
+		"Close":  timeoutmanagement.OutcomeNearMiss,
+		"Slow":   timeoutmanagement.OutcomeTimedOut,
+		"Broken": timeoutmanagement.OutcomeFailed,
+	}
+	results := m.Results()
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for _, r := range results {
+		if r.Outcome != want[r.Name] {
+			t.Errorf("%s: outcome %s, want %s", r.Name, r.Outcome, want[r.Name])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf, "example"); err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Results []struct {
+			Name    string `json:"name"`
+			Outcome string `json:"outcome"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON report: %v\n%s", err, buf.Bytes())
+	}
+	if len(report.Results) != 4 || report.Results[2].Outcome != "timed-out" {
+		t.Errorf("unexpected JSON report:\n%s", buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := m.WriteJUnit(&buf, "example"); err != nil {
+		t.Fatal(err)
+	}
+	var junit struct {
+		Suites []struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &junit); err != nil {
+		t.Fatalf("invalid JUnit report: %v\n%s", err, buf.Bytes())
+	}
+	if len(junit.Suites) != 1 || junit.Suites[0].Tests != 4 || junit.Suites[0].Failures != 2 {
+		t.Errorf("unexpected JUnit report:\n%s", buf.Bytes())
+	}
+}
+
+func TestRetry(t *testing.T) {
+	clk := timeoutmanagement.NewFakeClock(time.Now())
+	m := timeoutmanagement.NewTimeoutManager()
+	m.SetClock(clk)
+	errFlaky := errors.New("flaky")
+	m.RegisterTimeout(timeoutmanagement.TimeoutConfig{
+		Name: "Flaky",
+		Retry: &timeoutmanagement.RetryPolicy{
+			MaxAttempts:    4,
+			InitialBackoff: 100 * time.Millisecond,
+			Retryable:      func(err error) bool { return errors.Is(err, errFlaky) },
+		},
+	})
+
+	// Two failures, waiting 100ms and then 200ms before trying again. The
+	// backoff sleeps on the fake clock, which passes them at once.
+	var starts []time.Duration
+	begin := clk.Now()
+	err := m.ExecuteWithTimeout("Flaky", func(ctx context.Context) error {
+		starts = append(starts, clk.Now().Sub(begin))
+		if len(starts) < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Flaky failed: %v", err)
+	}
+	if want := []time.Duration{0, 100 * time.Millisecond, 300 * time.Millisecond}; !slices.Equal(starts, want) {
+		t.Errorf("attempts started at %v, want %v", starts, want)
+	}
+
+	// Errors the predicate rejects are not retried.
+	attempts := 0
+	err = m.ExecuteWithTimeout("Flaky", func(ctx context.Context) error {
+		attempts++
+		return errors.New("permanent")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("got %d attempts and error %v, want one attempt failing", attempts, err)
+	}
+
+	// Each attempt has its own timeout, and retries stop when the next
+	// attempt could not start within the budget.
+	m.RegisterTimeout(timeoutmanagement.TimeoutConfig{
+		Name:    "Hangs",
+		Timeout: 50 * time.Millisecond,
+		Budget:  200 * time.Millisecond,
+		Retry:   &timeoutmanagement.RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond},
+	})
+	attempts = 0
+	begin = clk.Now()
+	err = m.ExecuteWithTimeout("Hangs", func(ctx context.Context) error {
+		// Each attempt works until its timeout: 50ms, then a backoff of
+		// 100ms, then 50ms to the end of the budget.
+		attempts++
+		deadline, _ := ctx.Deadline()
+		return clk.Sleep(ctx, deadline.Sub(clk.Now()))
+	})
+	if elapsed := clk.Now().Sub(begin); elapsed != 200*time.Millisecond {
+		t.Errorf("Hangs took %s, want its budget of 200ms", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 2 {
+		t.Errorf("got %d attempts and error %v, want 2 attempts timing out", attempts, err)
+	}
+	if r := m.Results()[2]; r.Outcome != timeoutmanagement.OutcomeTimedOut || r.Attempts != 2 {
+		t.Errorf("got result %+v, want 2 attempts timed out", r)
+	}
+}
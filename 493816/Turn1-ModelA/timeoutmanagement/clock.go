@@ -0,0 +1,29 @@
+package timeoutmanagement
+
+import (
+	"time"
+
+	"clock"
+)
+
+// Clock is the source of time for the timeouts and recorded durations of a
+// TimeoutManager. The real clock is used unless TimeoutManager.SetClock
+// installs another, such as a FakeClock in tests.
+type Clock = clock.Clock
+
+// FakeClock is a Clock whose time only moves when it is advanced, so tests
+// of timeouts run in milliseconds whatever the timeouts are. A function
+// simulates work with Sleep, which advances the clock and reports a timeout
+// it runs into:
+//
+//	clk := timeoutmanagement.NewFakeClock(time.Now())
+//	tm.SetClock(clk)
+//	err := tm.ExecuteWithTimeout("SlowFunction", func(ctx context.Context) error {
+//		return clk.Sleep(ctx, 5*time.Second)
+//	})
+type FakeClock = clock.Fake
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFake(now)
+}
@@ -0,0 +1,244 @@
+package timeoutmanagement
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultNearMissThreshold is the fraction of its timeout an attempt may take
+// before it is reported as a near miss.
+const DefaultNearMissThreshold = 0.8
+
+// EnvReportDir names the directory timeouttest.RunAndReport writes reports
+// to when no directory is passed.
+const EnvReportDir = "TIMEOUT_REPORT_DIR"
+
+// Report file names written by WriteReports.
+const (
+	JUnitReportFile = "timeout-report.xml"
+	JSONReportFile  = "timeout-report.json"
+)
+
+// Outcome is how a call of ExecuteWithTimeout ended.
+type Outcome string
+
+const (
+	// OutcomePassed is a call that succeeded well within its timeout.
+	OutcomePassed Outcome = "passed"
+	// OutcomeNearMiss is a call that succeeded, but whose last attempt
+	// took more than the near-miss threshold of its timeout.
+	OutcomeNearMiss Outcome = "near-miss"
+	// OutcomeTimedOut is a call that failed by exceeding its timeout or
+	// budget.
+	OutcomeTimedOut Outcome = "timed-out"
+	// OutcomeFailed is a call that failed with another error.
+	OutcomeFailed Outcome = "failed"
+)
+
+// Result records one call of ExecuteWithTimeout.
+type Result struct {
+	Name string
+	// Duration is the time of the whole call, including retries.
+	Duration time.Duration
+	// LastAttempt is the time of the last attempt.
+	LastAttempt time.Duration
+	// Timeout is the timeout of each attempt.
+	Timeout  time.Duration
+	Attempts int
+	Outcome  Outcome
+	// Err is the error the call returned, if any.
+	Err error
+}
+
+// SetNearMissThreshold sets the fraction of its timeout, between 0 and 1, an
+// attempt may take before it is reported as a near miss. The default is
+// DefaultNearMissThreshold.
+func (tm *TimeoutManager) SetNearMissThreshold(threshold float64) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.nearMissThreshold = threshold
+}
+
+// Results returns the calls recorded so far, in the order they ended.
+func (tm *TimeoutManager) Results() []Result {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return append([]Result(nil), tm.results...)
+}
+
+// record adds the result of a call that took d, of which the last attempt
+// took last.
+func (tm *TimeoutManager) record(name string, d, last, timeout time.Duration, attempts int, err error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	outcome := OutcomePassed
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = OutcomeTimedOut
+	case err != nil:
+		outcome = OutcomeFailed
+	case timeout > 0 && float64(last) > tm.nearMissThreshold*float64(timeout):
+		outcome = OutcomeNearMiss
+	}
+	tm.results = append(tm.results, Result{Name: name, Duration: d, LastAttempt: last, Timeout: timeout, Attempts: attempts, Outcome: outcome, Err: err})
+}
+
+// jsonReport is the document written by WriteJSON.
+type jsonReport struct {
+	Suite             string       `json:"suite"`
+	NearMissThreshold float64      `json:"near_miss_threshold"`
+	Results           []jsonResult `json:"results"`
+}
+
+type jsonResult struct {
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	TimeoutSeconds  float64 `json:"timeout_seconds"`
+	Attempts        int     `json:"attempts"`
+	Outcome         Outcome `json:"outcome"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// WriteJSON writes the recorded results to w as a JSON document under the
+// given suite name.
+func (tm *TimeoutManager) WriteJSON(w io.Writer, suite string) error {
+	tm.mu.Lock()
+	report := jsonReport{Suite: suite, NearMissThreshold: tm.nearMissThreshold, Results: []jsonResult{}}
+	for _, r := range tm.results {
+		result := jsonResult{
+			Name:            r.Name,
+			DurationSeconds: r.Duration.Seconds(),
+			TimeoutSeconds:  r.Timeout.Seconds(),
+			Attempts:        r.Attempts,
+			Outcome:         r.Outcome,
+		}
+		if r.Err != nil {
+			result.Error = r.Err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+	tm.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// JUnit XML elements written by WriteJUnit.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+// WriteJUnit writes the recorded results to w as a JUnit XML test suite
+// with the given name. Calls that timed out or failed are failures; near
+// misses pass with a note in their output.
+func (tm *TimeoutManager) WriteJUnit(w io.Writer, suite string) error {
+	results := tm.Results()
+
+	s := junitSuite{Name: suite, Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+		c := junitCase{
+			Name:      r.Name,
+			Classname: suite,
+			Time:      seconds(r.Duration),
+			Properties: []junitProperty{
+				{Name: "timeout", Value: r.Timeout.String()},
+				{Name: "attempts", Value: fmt.Sprint(r.Attempts)},
+				{Name: "outcome", Value: string(r.Outcome)},
+			},
+		}
+		switch r.Outcome {
+		case OutcomeTimedOut:
+			s.Failures++
+			c.Failure = &junitFailure{Message: r.Err.Error(), Type: "timeout"}
+		case OutcomeFailed:
+			s.Failures++
+			c.Failure = &junitFailure{Message: r.Err.Error(), Type: "error"}
+		case OutcomeNearMiss:
+			c.SystemOut = fmt.Sprintf("near miss: took %s of %s timeout (%.0f%%)",
+				r.LastAttempt.Round(time.Millisecond), r.Timeout, 100*float64(r.LastAttempt)/float64(r.Timeout))
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{s}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteReports writes the JUnit and JSON reports of the recorded results,
+// as JUnitReportFile and JSONReportFile, to dir under the given suite name.
+// The directory is created if needed. See the timeouttest package to write
+// them at the end of a test binary.
+func (tm *TimeoutManager) WriteReports(dir, suite string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	writers := map[string]func(io.Writer, string) error{
+		JUnitReportFile: tm.WriteJUnit,
+		JSONReportFile:  tm.WriteJSON,
+	}
+	for name, write := range writers {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := write(f, suite); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package timeoutmanagement
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Defaults for the zero fields of RetryPolicy.
+const (
+	DefaultInitialBackoff    = 100 * time.Millisecond
+	DefaultBackoffMultiplier = 2
+)
+
+// RetryPolicy retries the failed attempts of ExecuteWithTimeout, waiting
+// with exponential backoff between them. An attempt that times out counts
+// as failed with an error wrapping context.DeadlineExceeded.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, multiplied
+	// by Multiplier after each further one, up to MaxBackoff if set.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter, between 0 and 1, shortens each wait by a random fraction of
+	// up to Jitter, so callers failing together do not retry together.
+	Jitter float64
+	// Retryable reports whether an error is worth another attempt. nil
+	// retries every error.
+	Retryable func(error) bool
+}
+
+// retryPolicy returns p with defaults applied; nil allows a single attempt.
+func retryPolicy(p *RetryPolicy) RetryPolicy {
+	if p == nil {
+		return RetryPolicy{MaxAttempts: 1}
+	}
+	policy := *p
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultInitialBackoff
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = DefaultBackoffMultiplier
+	}
+	policy.Jitter = min(max(policy.Jitter, 0), 1)
+	return policy
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// backoff returns the wait after the given failed attempt, counting from 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= p.Multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
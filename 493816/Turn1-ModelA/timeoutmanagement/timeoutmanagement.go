@@ -6,23 +6,50 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"clock"
 )
 
 // TimeoutConfig contains the configuration for a specific test timeout.
 type TimeoutConfig struct {
-	Name    string
+	Name string
+	// Timeout limits each attempt. Zero leaves attempts unlimited, bounded
+	// only by Budget.
 	Timeout time.Duration
+	// Retry, if set, retries failed attempts.
+	Retry *RetryPolicy
+	// Budget, if set, limits the whole call, including retries and the
+	// waits between them.
+	Budget time.Duration
 }
 
 // TimeoutManager manages the timeouts for various test operations.
 type TimeoutManager struct {
-	configs map[string]TimeoutConfig
-	mu      sync.Mutex
+	configs           map[string]TimeoutConfig
+	results           []Result
+	nearMissThreshold float64
+	clock             Clock
+	mu                sync.Mutex
 }
 
 // NewTimeoutManager returns a new TimeoutManager instance.
 func NewTimeoutManager() *TimeoutManager {
-	return &TimeoutManager{configs: make(map[string]TimeoutConfig)}
+	return &TimeoutManager{
+		configs:           make(map[string]TimeoutConfig),
+		nearMissThreshold: DefaultNearMissThreshold,
+		clock:             clock.Real,
+	}
+}
+
+// SetClock replaces the clock timeouts are measured on. nil restores the
+// real clock.
+func (tm *TimeoutManager) SetClock(c Clock) {
+	if c == nil {
+		c = clock.Real
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.clock = c
 }
 
 // RegisterTimeout registers a new timeout configuration for a test.
@@ -43,25 +70,72 @@ func (tm *TimeoutManager) GetTimeout(name string) (TimeoutConfig, error) {
 	return config, nil
 }
 
-// ExecuteWithTimeout runs the provided function with the specified timeout.
-func (tm *TimeoutManager) ExecuteWithTimeout(name string, f func()) {
+// ExecuteWithTimeout runs f under the configuration registered for name and
+// returns its error. Each attempt gets a context that expires after the
+// configured timeout, and f must return once it is done; an attempt that
+// times out fails with an error wrapping context.DeadlineExceeded. Failed
+// attempts are retried according to the Retry policy, as long as the next
+// attempt can start within the Budget. The outcome of the call is recorded
+// for the report; see Results.
+func (tm *TimeoutManager) ExecuteWithTimeout(name string, f func(ctx context.Context) error) error {
 	config, err := tm.GetTimeout(name)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	ctx, cancel := time.WithTimeout(context.Background(), config.Timeout)
-	defer cancel()
+	tm.mu.Lock()
+	clk := tm.clock
+	tm.mu.Unlock()
+
+	ctx := context.Background()
+	if config.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = clock.WithTimeout(clk, ctx, config.Budget)
+		defer cancel()
+	}
+	policy := retryPolicy(config.Retry)
 
-	go func() {
-		if err := f(); err != nil {
-			fmt.Printf("Test %q failed: %v\n", name, err)
+	start := clk.Now()
+	var last time.Duration
+	attempts := 0
+	for {
+		attempts++
+		attemptStart := clk.Now()
+		err = tm.attempt(ctx, clk, config, f)
+		last = clk.Now().Sub(attemptStart)
+		if err == nil || ctx.Err() != nil || attempts == policy.MaxAttempts || !policy.retryable(err) {
+			break
+		}
+		backoff := policy.backoff(attempts)
+		if deadline, ok := ctx.Deadline(); ok && !clk.Now().Add(backoff).Before(deadline) {
+			break // the next attempt could not start within the budget
+		}
+		if clk.Sleep(ctx, backoff) != nil {
+			break
 		}
-	}()
+	}
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%q exceeded its budget of %s: %w", name, config.Budget, context.DeadlineExceeded)
+	}
+	if err != nil && attempts > 1 {
+		err = fmt.Errorf("%q failed after %d attempts: %w", name, attempts, err)
+	}
+	tm.record(name, clk.Now().Sub(start), last, config.Timeout, attempts, err)
+	return err
+}
+
+// attempt runs f once with the timeout of config.
+func (tm *TimeoutManager) attempt(ctx context.Context, clk Clock, config TimeoutConfig, f func(ctx context.Context) error) error {
+	var cancel context.CancelFunc
+	if config.Timeout > 0 {
+		ctx, cancel = clock.WithTimeout(clk, ctx, config.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
 
-	select {
-	case <-ctx.Done():
-		fmt.Printf("Test %q timed out after %s\n", name, config.Timeout)
-	default:
+	err := f(ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%q timed out after %s: %w", config.Name, config.Timeout, context.DeadlineExceeded)
 	}
+	return err
 }
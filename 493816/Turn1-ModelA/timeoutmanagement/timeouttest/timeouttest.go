@@ -0,0 +1,42 @@
+// Package timeouttest writes the reports of a timeoutmanagement.TimeoutManager
+// at the end of a test binary. It is kept apart from timeoutmanagement so that
+// programs using the manager do not import package testing.
+package timeouttest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"modela/timeoutmanagement"
+)
+
+// RunAndReport runs the tests and writes the JUnit and JSON reports of the
+// results recorded by tm to dir, or to the directory named by
+// timeoutmanagement.EnvReportDir if dir is empty. With neither set no
+// reports are written. The suite is named after the test binary, e.g.
+// "testpackage1" for "testpackage1.test". It returns the exit code to pass
+// to os.Exit, for use in TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(timeouttest.RunAndReport(m, tm, ""))
+//	}
+func RunAndReport(m *testing.M, tm *timeoutmanagement.TimeoutManager, dir string) int {
+	code := m.Run()
+	if dir == "" {
+		dir = os.Getenv(timeoutmanagement.EnvReportDir)
+	}
+	if dir == "" {
+		return code
+	}
+	suite := strings.TrimSuffix(filepath.Base(os.Args[0]), ".test")
+	if err := tm.WriteReports(dir, suite); err != nil {
+		fmt.Fprintf(os.Stderr, "timeoutmanagement: writing reports: %v\n", err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,339 @@
+// Command testtimeout runs go test -json and enforces the per-test timeouts
+// of the timeout package from outside the test binary, so that even a test
+// that ignores its context cannot hang the run.
+//
+// Usage:
+//
+//	testtimeout [-json] [-grace duration] [--] [go test arguments]
+//
+// The output of the tests is streamed as it arrives, or the events
+// themselves with -json. Each top-level test gets the timeout that
+// timeout.GetTestTimeout gives it, read from the configuration found from
+// the working directory. A test still running grace after its timeout has
+// the test binary sent SIGQUIT, which prints the stacks of all its
+// goroutines and exits it; one still running another grace later is killed.
+// A summary of the results is printed at the end, and the exit status is
+// non-zero if any test failed or was stopped.
+//
+// Packages are tested one at a time, so that stopping one test binary does
+// not affect the tests of other packages.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"modela/timeout"
+)
+
+func main() {
+	jsonOut := flag.Bool("json", false, "print the go test -json events instead of the test output")
+	grace := flag.Duration("grace", 5*time.Second, "how long a test may overrun its timeout before it is stopped")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: testtimeout [-json] [-grace duration] [--] [go test arguments]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	os.Exit(run(flag.Args(), *jsonOut, *grace))
+}
+
+// event is an event of go test -json; see go doc test2json.
+type event struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func run(args []string, jsonOut bool, grace time.Duration) int {
+	cmd := exec.Command("go", append([]string{"test", "-json", "-p=1"}, args...)...)
+	cmd.Stderr = os.Stderr
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testtimeout: %v\n", err)
+		return 2
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "testtimeout: %v\n", err)
+		return 2
+	}
+
+	// The go command and test binaries are in their own process group, so
+	// pass on interrupts.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(stdout)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) > 0 {
+				lines <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	r := newRunner(time.Now, grace, timeout.GetTestTimeout)
+	start := time.Now()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				break loop
+			}
+			var ev event
+			if err := json.Unmarshal(line, &ev); err != nil {
+				os.Stdout.Write(line) // not an event, e.g. a build error
+				continue
+			}
+			if jsonOut {
+				os.Stdout.Write(line)
+			} else if ev.Action == "output" {
+				io.WriteString(os.Stdout, ev.Output)
+			}
+			r.handle(ev)
+		case <-ticker.C:
+			for _, s := range r.stop() {
+				fmt.Fprintf(os.Stderr, "testtimeout: %s\n", s.reason)
+				if err := s.signal(cmd); err != nil {
+					fmt.Fprintf(os.Stderr, "testtimeout: signalling test binary: %v\n", err)
+				}
+			}
+		case <-interrupts:
+			interrupt(cmd)
+		}
+	}
+	err = cmd.Wait()
+	r.finish()
+
+	r.summarize(os.Stdout, time.Since(start))
+	if err != nil || r.failed() {
+		return 1
+	}
+	return 0
+}
+
+// stopAction is a signal to send to the test binary.
+type stopAction struct {
+	reason string
+	signal func(*exec.Cmd) error
+}
+
+// testKey identifies a test.
+type testKey struct {
+	pkg, test string
+}
+
+type runningTest struct {
+	started time.Time
+	timeout time.Duration
+	// quitAt is when the test binary was sent SIGQUIT, zero before.
+	quitAt time.Time
+	killed bool
+}
+
+type packageResult struct {
+	action  string // pass, fail or skip; empty while running
+	passed  int
+	skipped int
+	failed  []string
+	stopped []string
+}
+
+// runner tracks the tests of a go test -json run and decides when to stop
+// the test binary.
+type runner struct {
+	now    func() time.Time
+	grace  time.Duration
+	lookup func(pkg, test string) (time.Duration, error)
+
+	running  map[testKey]*runningTest
+	packages map[string]*packageResult
+	order    []string
+	warned   bool
+}
+
+// newRunner returns a runner telling time with now and looking timeouts up
+// with lookup.
+func newRunner(now func() time.Time, grace time.Duration, lookup func(pkg, test string) (time.Duration, error)) *runner {
+	return &runner{
+		now:      now,
+		grace:    grace,
+		lookup:   lookup,
+		running:  make(map[testKey]*runningTest),
+		packages: make(map[string]*packageResult),
+	}
+}
+
+func (r *runner) pkg(name string) *packageResult {
+	p, ok := r.packages[name]
+	if !ok {
+		p = &packageResult{}
+		r.packages[name] = p
+		r.order = append(r.order, name)
+	}
+	return p
+}
+
+// handle updates the state of the run with an event.
+func (r *runner) handle(ev event) {
+	p := r.pkg(ev.Package)
+	key := testKey{ev.Package, ev.Test}
+
+	if ev.Test == "" {
+		switch ev.Action {
+		case "pass", "fail", "skip":
+			p.action = ev.Action
+			// Tests of a binary that exited while they ran get no event.
+			for _, k := range r.sortedRunning() {
+				if t := r.running[k]; k.pkg == ev.Package {
+					if !t.quitAt.IsZero() {
+						p.stopped = append(p.stopped, fmt.Sprintf("%s (timeout %s)", k.test, t.timeout))
+					} else {
+						p.failed = append(p.failed, k.test)
+					}
+					delete(r.running, k)
+				}
+			}
+		}
+		return
+	}
+
+	switch ev.Action {
+	case "run":
+		// Only top-level tests have timeouts; subtests share them.
+		if strings.Contains(ev.Test, "/") {
+			return
+		}
+		d, err := r.lookup(ev.Package, ev.Test)
+		if err != nil {
+			if !r.warned {
+				fmt.Fprintf(os.Stderr, "testtimeout: %v; timeouts are not enforced\n", err)
+				r.warned = true
+			}
+			return
+		}
+		r.running[key] = &runningTest{started: r.now(), timeout: d}
+	case "cont":
+		// A parallel test's time starts when it is resumed.
+		if t, ok := r.running[key]; ok {
+			t.started = r.now()
+		}
+	case "pass":
+		p.passed++
+		delete(r.running, key)
+	case "skip":
+		p.skipped++
+		delete(r.running, key)
+	case "fail":
+		p.failed = append(p.failed, ev.Test)
+		delete(r.running, key)
+	}
+}
+
+// finish closes out the tests still running when the events end, as when a
+// killed test binary left its package without a final event. They count as
+// stopped, and their packages as failed.
+func (r *runner) finish() {
+	for _, k := range r.sortedRunning() {
+		t := r.running[k]
+		p := r.pkg(k.pkg)
+		reason := "no result"
+		if !t.quitAt.IsZero() {
+			reason = fmt.Sprintf("timeout %s", t.timeout)
+		}
+		p.stopped = append(p.stopped, fmt.Sprintf("%s (%s)", k.test, reason))
+		p.action = "fail"
+		delete(r.running, k)
+	}
+}
+
+// stop returns the signals to send for tests that have overrun: SIGQUIT
+// grace after a test's timeout, and SIGKILL grace after that.
+func (r *runner) stop() []stopAction {
+	now := r.now()
+	var actions []stopAction
+	for _, k := range r.sortedRunning() {
+		t := r.running[k]
+		switch {
+		case t.quitAt.IsZero() && now.Sub(t.started) > t.timeout+r.grace:
+			t.quitAt = now
+			actions = append(actions, stopAction{
+				reason: fmt.Sprintf("%s in %s has run for %s, over its timeout of %s; dumping goroutines and exiting the test binary",
+					k.test, k.pkg, now.Sub(t.started).Round(time.Millisecond), t.timeout),
+				signal: quit,
+			})
+		case !t.quitAt.IsZero() && !t.killed && now.Sub(t.quitAt) > r.grace:
+			t.killed = true
+			actions = append(actions, stopAction{
+				reason: fmt.Sprintf("test binary of %s did not exit; killing it", k.pkg),
+				signal: kill,
+			})
+		}
+	}
+	return actions
+}
+
+func (r *runner) sortedRunning() []testKey {
+	keys := make([]testKey, 0, len(r.running))
+	for k := range r.running {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b testKey) int {
+		return strings.Compare(a.pkg+"\x00"+a.test, b.pkg+"\x00"+b.test)
+	})
+	return keys
+}
+
+// failed reports whether any package or test failed or was stopped.
+func (r *runner) failed() bool {
+	for _, p := range r.packages {
+		if p.action == "fail" || len(p.failed) > 0 || len(p.stopped) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// summarize prints the results of each package.
+func (r *runner) summarize(w io.Writer, elapsed time.Duration) {
+	fmt.Fprintf(w, "\ntesttimeout: %d package(s) in %s\n", len(r.order), elapsed.Round(time.Millisecond))
+	for _, name := range r.order {
+		p := r.packages[name]
+		status := "ok  "
+		switch {
+		case p.action == "fail" || len(p.failed) > 0 || len(p.stopped) > 0:
+			status = "FAIL"
+		case p.action == "skip":
+			status = "skip"
+		}
+		fmt.Fprintf(w, "%s  %s  %d passed, %d failed, %d stopped, %d skipped\n",
+			status, name, p.passed, len(p.failed), len(p.stopped), p.skipped)
+		for _, test := range p.stopped {
+			fmt.Fprintf(w, "        stopped: %s\n", test)
+		}
+		for _, test := range p.failed {
+			fmt.Fprintf(w, "        failed:  %s\n", test)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	now := time.Unix(0, 0)
+	lookup := func(pkg, test string) (time.Duration, error) {
+		if test == "TestSlow" {
+			return 10 * time.Second, nil
+		}
+		return time.Second, nil
+	}
+	r := newRunner(func() time.Time { return now }, 500*time.Millisecond, lookup)
+
+	for _, ev := range []event{
+		{Action: "run", Package: "p", Test: "TestFast"},
+		{Action: "pass", Package: "p", Test: "TestFast"},
+		{Action: "run", Package: "p", Test: "TestSlow"},
+		{Action: "run", Package: "p", Test: "TestSlow/case"},
+	} {
+		r.handle(ev)
+	}
+
+	// TestSlow is within its timeout, and its subtest shares it.
+	now = now.Add(10 * time.Second)
+	if actions := r.stop(); len(actions) != 0 {
+		t.Fatalf("Expected no action within the timeout, got %d", len(actions))
+	}
+
+	// Past the timeout and grace the binary is sent SIGQUIT, then killed.
+	now = now.Add(time.Second)
+	actions := r.stop()
+	if len(actions) != 1 || !strings.Contains(actions[0].reason, "TestSlow in p has run for 11s, over its timeout of 10s") {
+		t.Fatalf("Expected SIGQUIT for TestSlow, got %+v", actions)
+	}
+	if actions := r.stop(); len(actions) != 0 {
+		t.Fatalf("Expected SIGQUIT to be sent once, got %d actions", len(actions))
+	}
+	now = now.Add(time.Second)
+	if actions := r.stop(); len(actions) != 1 || !strings.Contains(actions[0].reason, "killing") {
+		t.Fatalf("Expected SIGKILL after another grace period, got %+v", actions)
+	}
+
+	r.handle(event{Action: "fail", Package: "p"})
+	r.handle(event{Action: "run", Package: "q", Test: "TestOther"})
+	r.handle(event{Action: "fail", Package: "q", Test: "TestOther"})
+	r.handle(event{Action: "fail", Package: "q"})
+	if !r.failed() {
+		t.Error("Expected the run to have failed")
+	}
+
+	var out bytes.Buffer
+	r.summarize(&out, 12*time.Second)
+	for _, want := range []string{
+		"FAIL  p  1 passed, 0 failed, 1 stopped, 0 skipped",
+		"stopped: TestSlow (timeout 10s)",
+		"FAIL  q  0 passed, 1 failed, 0 stopped, 0 skipped",
+		"failed:  TestOther",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunnerKilledPackage(t *testing.T) {
+	now := time.Unix(0, 0)
+	lookup := func(pkg, test string) (time.Duration, error) { return time.Second, nil }
+	r := newRunner(func() time.Time { return now }, time.Second, lookup)
+
+	r.handle(event{Action: "run", Package: "p", Test: "TestDone"})
+	r.handle(event{Action: "pass", Package: "p", Test: "TestDone"})
+	r.handle(event{Action: "run", Package: "p", Test: "TestHang"})
+	now = now.Add(3 * time.Second)
+	if actions := r.stop(); len(actions) != 1 {
+		t.Fatalf("Expected SIGQUIT for p, got %+v", actions)
+	}
+	now = now.Add(2 * time.Second)
+	if actions := r.stop(); len(actions) != 1 || !strings.Contains(actions[0].reason, "killing") {
+		t.Fatalf("Expected SIGKILL for p, got %+v", actions)
+	}
+
+	// The events end without a result for p.
+	r.finish()
+	if len(r.running) != 0 {
+		t.Errorf("Expected no test left running, got %d", len(r.running))
+	}
+	if !r.failed() {
+		t.Error("Expected the run to have failed")
+	}
+
+	var out bytes.Buffer
+	r.summarize(&out, 5*time.Second)
+	for _, want := range []string{
+		"FAIL  p  1 passed, 0 failed, 1 stopped, 0 skipped",
+		"stopped: TestHang (timeout 1s)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
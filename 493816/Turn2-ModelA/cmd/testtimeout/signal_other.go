@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func interrupt(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// quit kills the go command. Without process groups the test binary cannot
+// be signalled on its own, so there is no goroutine dump and the packages
+// left are not tested.
+func quit(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a process group of its own, shared with the
+// test binaries it runs.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interrupt sends SIGINT to the process group of cmd, as Ctrl-C would, so
+// that the go command stops the run.
+func interrupt(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+// quit sends SIGQUIT to the running test binary of cmd, which prints its
+// goroutines and exits. The go command goes on with the next package.
+func quit(cmd *exec.Cmd) error {
+	return signalTestBinaries(cmd, syscall.SIGQUIT)
+}
+
+// kill kills the running test binary of cmd.
+func kill(cmd *exec.Cmd) error {
+	return signalTestBinaries(cmd, syscall.SIGKILL)
+}
+
+func signalTestBinaries(cmd *exec.Cmd, sig syscall.Signal) error {
+	pids, err := testBinaries(cmd.Process.Pid)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return errors.New("no test binary is running")
+	}
+	for _, pid := range pids {
+		// The binary may have exited since it was listed.
+		if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+// testBinaries returns the processes in the process group led by pgid, other
+// than its leader, whose executable name ends in ".test".
+func testBinaries(pgid int) ([]int, error) {
+	out, err := exec.Command("ps", "-A", "-o", "pid=", "-o", "pgid=", "-o", "args=").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing processes: %w", err)
+	}
+	var pids []int
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || pid == pgid {
+			continue
+		}
+		if group, err := strconv.Atoi(fields[1]); err != nil || group != pgid {
+			continue
+		}
+		if strings.HasSuffix(fields[2], ".test") {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
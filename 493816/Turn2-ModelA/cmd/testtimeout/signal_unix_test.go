@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTestBinaries(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("no sleep command")
+	}
+	data, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(t.TempDir(), "pkg.test")
+	if err := os.WriteFile(bin, data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A shell stands in for the go command, leading the process group.
+	cmd := exec.Command("sh", "-c", bin+" 30 & wait")
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	var pids []int
+	for deadline := time.Now().Add(5 * time.Second); len(pids) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		if pids, err = testBinaries(cmd.Process.Pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pids) != 1 || slices.Contains(pids, cmd.Process.Pid) {
+		t.Fatalf("Expected the one test binary, got %v", pids)
+	}
+
+	if err := kill(cmd); err != nil {
+		t.Fatal(err)
+	}
+	// Only the test binary is killed; the shell sees it exit.
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Expected the shell to outlive the test binary, got %v", err)
+	}
+}
@@ -1,3 +1,10 @@
 module modela
 
 go 1.22.3
+
+require (
+	clock v0.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace clock => ../clock
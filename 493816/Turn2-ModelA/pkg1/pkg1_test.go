@@ -9,25 +9,25 @@ import (
 )
 
 func TestFast(t *testing.T) {
+	clk := timeout.UseFakeClock(t)
+
 	// Use the timeout management interface
-	timeout.ApplyTimeoutToTest("TestFast", func(ctx context.Context) {
+	timeout.Run(t, func(ctx context.Context) {
 		// Your test logic here
-		select {
-		case <-time.After(500 * time.Millisecond): // Example: Fast test
-		case <-ctx.Done():
-			t.Fatal("TestFast timed out")
+		if err := clk.Sleep(ctx, 500*time.Millisecond); err != nil { // Example: Fast test
+			return // Timed out: Run fails the test, just stop working
 		}
 	})
 }
 
 func TestLongRunning(t *testing.T) {
+	clk := timeout.UseFakeClock(t)
+
 	// Use the timeout management interface
-	timeout.ApplyTimeoutToTest("TestLongRunning", func(ctx context.Context) {
+	timeout.Run(t, func(ctx context.Context) {
 		// Your test logic here
-		select {
-		case <-time.After(8 * time.Second): // Example: Long running test
-		case <-ctx.Done():
-			t.Fatal("TestLongRunning timed out")
+		if err := clk.Sleep(ctx, 8*time.Second); err != nil { // Example: Long running test
+			return // Timed out: Run fails the test, just stop working
 		}
 	})
 }
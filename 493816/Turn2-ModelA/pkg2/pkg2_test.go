@@ -1,6 +1,7 @@
 package pkg2
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -8,17 +9,25 @@ import (
 )
 
 func TestDatabaseConnection(t *testing.T) {
+	clk := timeout.UseFakeClock(t)
+
 	// Use the timeout management interface
-	timeout.ApplyTimeoutToTest("TestDatabaseConnection", func() {
+	timeout.Run(t, func(ctx context.Context) {
 		// Your test logic here
-		time.Sleep(3 * time.Second) // Example: Normal test
+		if err := clk.Sleep(ctx, 3*time.Second); err != nil { // Example: Normal test
+			return // Timed out: Run fails the test, just stop working
+		}
 	})
 }
 
 func TestAPIRequest(t *testing.T) {
+	clk := timeout.UseFakeClock(t)
+
 	// Use the timeout management interface
-	timeout.ApplyTimeoutToTest("TestAPIRequest", func() {
+	timeout.Run(t, func(ctx context.Context) {
 		// Your test logic here
-		time.Sleep(2 * time.Second) // Example: API test
+		if err := clk.Sleep(ctx, 2*time.Second); err != nil { // Example: API test
+			return // Timed out: Run fails the test, just stop working
+		}
 	})
 }
@@ -0,0 +1,119 @@
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"clock"
+)
+
+// Budget divides what is left of a test's timeout among its subtests, for
+// table-driven tests. Create one with the context Run passes to the test
+// body and run each subtest through it:
+//
+//	timeout.Run(t, func(ctx context.Context) {
+//		budget := timeout.NewBudget(ctx, len(tests))
+//		for _, tt := range tests {
+//			budget.Run(t, tt.name, func(t *testing.T, ctx context.Context) {
+//				// ...
+//			})
+//		}
+//	})
+//
+// Each subtest gets an equal share of the time left until the parent's
+// deadline, divided among the subtests not yet started, so time left over
+// by a fast subtest goes to the ones after it. A share is further capped by
+// SetCap and by the first configuration rule matching the subtest's full
+// name, such as "TestTable/slow_case"; see Config. A subtest's context is
+// derived from its parent's, so a parent that expires cancels its running
+// subtest too.
+//
+// Subtests are given half the grace period of their parent, so that a
+// cancelled subtest is failed before its parent is. Subtests run one at a
+// time; Budget does not support t.Parallel.
+type Budget struct {
+	ctx context.Context
+	pkg string
+
+	mu        sync.Mutex
+	remaining int
+	cap       time.Duration
+}
+
+// NewBudget returns a Budget dividing the time left until the deadline of
+// ctx among n subtests. If ctx has no deadline only caps apply.
+func NewBudget(ctx context.Context, n int) *Budget {
+	return &Budget{ctx: ctx, pkg: callerPackage(1), remaining: n}
+}
+
+// SetCap limits the timeout of each subtest to d. Zero removes the cap.
+func (b *Budget) SetCap(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cap = d
+}
+
+// Run runs f as the subtest name of t with its share of the budget and
+// reports whether it succeeded, like t.Run. Once the parent has expired no
+// more subtests are started and Run returns false.
+//
+// f runs on its own goroutine, so it must report failures with t.Error
+// rather than t.Fatal.
+func (b *Budget) Run(t *testing.T, name string, f func(t *testing.T, ctx context.Context)) bool {
+	t.Helper()
+	if b.ctx.Err() != nil {
+		return false
+	}
+	return t.Run(name, func(t *testing.T) {
+		t.Helper()
+		timeout, err := b.share(t.Name())
+		if err != nil {
+			t.Fatalf("test %s: %v", t.Name(), err)
+		}
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = clock.WithTimeout(currentClock(), b.ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(b.ctx)
+		}
+		defer cancel()
+		ctx = withGrace(ctx, graceOf(b.ctx)/2)
+
+		runBody(t, ctx, func(ctx context.Context) { f(t, ctx) }, func() string {
+			if b.ctx.Err() != nil {
+				return fmt.Sprintf("test %s cancelled: parent test expired", t.Name())
+			}
+			return fmt.Sprintf("test %s timed out after its budget of %s", t.Name(), timeout)
+		})
+	})
+}
+
+// share returns the timeout of the next subtest, or zero if it has none,
+// and counts the subtest as started.
+func (b *Budget) share(testName string) (time.Duration, error) {
+	b.mu.Lock()
+	n := max(b.remaining, 1)
+	b.remaining--
+	limit := b.cap
+	b.mu.Unlock()
+
+	if rule, ok, err := ruleTimeout(b.pkg, testName); err != nil {
+		return 0, err
+	} else if ok && (limit == 0 || rule < limit) {
+		limit = rule
+	}
+
+	timeout := limit
+	if deadline, ok := b.ctx.Deadline(); ok {
+		// At least a nanosecond, as zero means no timeout.
+		share := max(deadline.Sub(currentClock().Now())/time.Duration(n), 1)
+		if timeout == 0 || share < timeout {
+			timeout = share
+		}
+	}
+	return timeout, nil
+}
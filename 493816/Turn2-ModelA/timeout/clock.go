@@ -0,0 +1,62 @@
+package timeout
+
+import (
+	"testing"
+	"time"
+
+	"clock"
+)
+
+// Clock is the source of time for timeouts and grace periods. The real
+// clock is used unless SetClock installs another, such as a FakeClock in
+// tests.
+type Clock = clock.Clock
+
+// FakeClock is a Clock whose time only moves when it is advanced, so tests
+// of timeouts run in milliseconds whatever the timeouts are. A test body
+// simulates work with Sleep, which advances the clock and reports a
+// timeout it runs into:
+//
+//	clk := timeout.UseFakeClock(t)
+//	timeout.Run(t, func(ctx context.Context) {
+//		if err := clk.Sleep(ctx, 8*time.Second); err != nil {
+//			return // timed out: Run fails the test
+//		}
+//	})
+type FakeClock = clock.Fake
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFake(now)
+}
+
+var installed = clock.Real
+
+// SetClock replaces the clock used by the package. nil restores the real
+// clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = clock.Real
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	installed = c
+}
+
+// UseFakeClock installs a FakeClock set to the current time until the end
+// of t, when the clock in place before is restored.
+func UseFakeClock(t testing.TB) *FakeClock {
+	t.Helper()
+	prev := currentClock()
+	clk := NewFakeClock(time.Now())
+	SetClock(clk)
+	t.Cleanup(func() { SetClock(prev) })
+	return clk
+}
+
+// currentClock returns the clock installed by SetClock.
+func currentClock() Clock {
+	mu.RLock()
+	defer mu.RUnlock()
+	return installed
+}
@@ -0,0 +1,400 @@
+package timeout
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables read when the configuration is first needed.
+const (
+	// EnvConfig names the configuration file. Without it ConfigFileName, or
+	// else one of YAMLConfigFileNames, is looked up from the working
+	// directory up to the module root.
+	EnvConfig = "TEST_TIMEOUT_CONFIG"
+	// EnvMultiplier overrides Config.Multiplier, e.g. "3" on slow CI runners.
+	EnvMultiplier = "TEST_TIMEOUT_MULTIPLIER"
+	// EnvDefault overrides Config.Default, e.g. "30s".
+	EnvDefault = "TEST_TIMEOUT_DEFAULT"
+)
+
+// ConfigFileName is the configuration file looked up when EnvConfig is not
+// set.
+const ConfigFileName = "timeouts.json"
+
+// YAMLConfigFileNames are the YAML configuration files looked up, in order,
+// in a directory without ConfigFileName.
+var YAMLConfigFileNames = []string{"timeouts.yaml", "timeouts.yml"}
+
+// Config holds the timeouts of a module's tests. In JSON:
+//
+//	{
+//	  "default": "5s",
+//	  "multiplier": 1,
+//	  "rules": [
+//	    {"package": "modela/pkg1", "test": "TestLongRunning", "timeout": "10s"},
+//	    {"test": "TestFast*", "timeout": "1s"}
+//	  ],
+//	  "adaptive": {"percentile": 99, "safety_factor": 3, "min_samples": 20},
+//	  "leaks": {"grace": "1s", "allow": ["net/http.(*persistConn)"]}
+//	}
+//
+// A file whose name ends in ".yaml" or ".yml" is read as YAML with the same
+// keys:
+//
+//	default: 5s
+//	rules:
+//	  - package: modela/pkg1
+//	    test: TestLongRunning
+//	    timeout: 10s
+type Config struct {
+	// Default applies to tests no rule matches. Zero uses the timeout set by
+	// SetDefaultTimeout.
+	Default Duration `json:"default"`
+	// Multiplier scales every timeout. Zero means 1.
+	Multiplier float64 `json:"multiplier"`
+	// Rules are checked in order and the first match wins, so more
+	// specific rules go first.
+	Rules []Rule `json:"rules"`
+	// Adaptive, if set, learns timeouts for tests no rule matches.
+	Adaptive *AdaptiveConfig `json:"adaptive,omitempty"`
+	// Leaks, if set, fails tests that leave goroutines running.
+	Leaks *LeakConfig `json:"leaks,omitempty"`
+
+	history *history
+}
+
+// Rule sets the timeout of the tests it matches. Package is matched against
+// the import path of the test's package and Test against the test name,
+// including any subtest path. Both are globs in which "*" does not cross "/"
+// and a "**" segment matches any number of segments; an empty glob matches
+// anything.
+type Rule struct {
+	Package string   `json:"package,omitempty"`
+	Test    string   `json:"test,omitempty"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Duration is a time.Duration written as a string such as "1m30s" in JSON
+// and YAML.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// builtinConfig is used when there is no configuration file.
+var builtinConfig = Config{
+	Rules: []Rule{
+		{Test: "TestLongRunning", Timeout: Duration(10 * time.Second)},
+		{Test: "TestFast", Timeout: Duration(1 * time.Second)},
+	},
+}
+
+var (
+	mu        sync.RWMutex
+	config    *Config
+	configErr error
+)
+
+// LoadConfig reads a configuration file, as YAML if its name ends in ".yaml"
+// or ".yml" and as JSON otherwise.
+func LoadConfig(name string) (*Config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	for i, rule := range c.Rules {
+		if rule.Timeout <= 0 {
+			return nil, fmt.Errorf("parsing %s: rule %d has no timeout", name, i+1)
+		}
+	}
+	if c.Multiplier < 0 {
+		return nil, fmt.Errorf("parsing %s: negative multiplier", name)
+	}
+	c.openHistory(filepath.Dir(name))
+	return &c, nil
+}
+
+// yamlToJSON converts a YAML document to JSON, so that YAML configurations
+// are read by the same JSON field names and Duration parsing.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		// An empty document is an empty configuration.
+		return []byte("{}"), nil
+	}
+	return json.Marshal(doc)
+}
+
+// SetConfig replaces the configuration, including one loaded from a file.
+// Environment overrides still apply. The history file of a Config that was
+// not loaded from a file is relative to the working directory.
+func SetConfig(c *Config) {
+	if c.history == nil {
+		c.openHistory(".")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	config, configErr = c, nil
+}
+
+// openHistory sets up the history of an adaptive configuration whose file
+// lives in dir.
+func (c *Config) openHistory(dir string) {
+	if c.Adaptive == nil {
+		return
+	}
+	name := c.Adaptive.withDefaults().History
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	c.history = newHistory(name)
+}
+
+// Lookup returns the timeout for a test, before the multiplier: that of the
+// first matching rule, else a learned timeout, else the default.
+func (c *Config) Lookup(pkg, testName string) time.Duration {
+	if timeout, ok := c.rule(pkg, testName); ok {
+		return timeout
+	}
+	if c.history != nil {
+		if learned, ok := c.history.learned(historyKey(pkg, testName), c.Adaptive.withDefaults()); ok {
+			return learned
+		}
+	}
+	if c.Default > 0 {
+		return time.Duration(c.Default)
+	}
+	return defaultTimeout
+}
+
+// rule returns the timeout of the first rule matching a test.
+func (c *Config) rule(pkg, testName string) (time.Duration, bool) {
+	for _, rule := range c.Rules {
+		if (rule.Package == "" || matchGlob(rule.Package, pkg)) && (rule.Test == "" || matchGlob(rule.Test, testName)) {
+			return time.Duration(rule.Timeout), true
+		}
+	}
+	return 0, false
+}
+
+// scale applies the multiplier to a timeout.
+func (c *Config) scale(timeout time.Duration) time.Duration {
+	if c.Multiplier > 0 {
+		return time.Duration(float64(timeout) * c.Multiplier)
+	}
+	return timeout
+}
+
+// GetTestTimeout returns the timeout for a test of the package with import
+// path pkg, with environment overrides and the multiplier applied.
+func GetTestTimeout(pkg, testName string) (time.Duration, error) {
+	c, err := effectiveConfig()
+	if err != nil {
+		return 0, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return c.scale(c.Lookup(pkg, testName)), nil
+}
+
+// ruleTimeout returns the timeout of the first rule matching a test, with
+// environment overrides and the multiplier applied.
+func ruleTimeout(pkg, testName string) (time.Duration, bool, error) {
+	c, err := effectiveConfig()
+	if err != nil {
+		return 0, false, err
+	}
+	timeout, ok := c.rule(pkg, testName)
+	return c.scale(timeout), ok, nil
+}
+
+// effectiveConfig returns a copy of the configuration with environment
+// overrides applied.
+func effectiveConfig() (Config, error) {
+	c, err := activeConfig()
+	if err != nil {
+		return Config{}, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	effective := *c
+	if err := applyEnv(&effective); err != nil {
+		return Config{}, err
+	}
+	return effective, nil
+}
+
+// activeConfig returns the configuration, loading it on first use.
+func activeConfig() (*Config, error) {
+	mu.RLock()
+	c, err := config, configErr
+	mu.RUnlock()
+	if c != nil || err != nil {
+		return c, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if config == nil && configErr == nil {
+		config, configErr = findConfig()
+	}
+	return config, configErr
+}
+
+// findConfig loads the file named by EnvConfig, or the nearest
+// ConfigFileName or YAMLConfigFileNames between the working directory and
+// the module root, or falls back to builtinConfig.
+func findConfig() (*Config, error) {
+	if name := os.Getenv(EnvConfig); name != "" {
+		return LoadConfig(name)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return &builtinConfig, nil
+	}
+	for {
+		for _, base := range append([]string{ConfigFileName}, YAMLConfigFileNames...) {
+			name := filepath.Join(dir, base)
+			if _, err := os.Stat(name); err == nil {
+				return LoadConfig(name)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return &builtinConfig, nil
+}
+
+// applyEnv applies EnvDefault and EnvMultiplier to c.
+func applyEnv(c *Config) error {
+	if s := os.Getenv(EnvDefault); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s: %q", EnvDefault, s)
+		}
+		c.Default = Duration(d)
+	}
+	if s := os.Getenv(EnvMultiplier); s != "" {
+		m, err := strconv.ParseFloat(s, 64)
+		if err != nil || m <= 0 {
+			return fmt.Errorf("invalid %s: %q", EnvMultiplier, s)
+		}
+		c.Multiplier = m
+	}
+	return nil
+}
+
+// recordDuration adds the duration of a passing test to the history of an
+// adaptive configuration.
+func recordDuration(pkg, testName string, d time.Duration) error {
+	c, err := activeConfig()
+	if err != nil || c.history == nil {
+		return err
+	}
+	return c.history.record(historyKey(pkg, testName), d, c.Adaptive.withDefaults().MaxSamples)
+}
+
+// timeoutOrDefault is GetTestTimeout for callers that cannot report an
+// error. A broken configuration is reported once on stderr and the default
+// timeout is used instead.
+func timeoutOrDefault(pkg, testName string) time.Duration {
+	timeout, err := GetTestTimeout(pkg, testName)
+	if err != nil {
+		warnOnce.Do(func() {
+			fmt.Fprintf(os.Stderr, "timeout: %v; using default timeout\n", err)
+		})
+		mu.RLock()
+		defer mu.RUnlock()
+		return defaultTimeout
+	}
+	return timeout
+}
+
+var warnOnce sync.Once
+
+// callerPackage returns the import path of the package of the function skip
+// frames above its caller.
+func callerPackage(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return ""
+	}
+	// Function names look like "example.com/mod/pkg.TestName.func1".
+	name := fn.Name()
+	slash := max(strings.LastIndex(name, "/"), 0)
+	if dot := strings.Index(name[slash:], "."); dot >= 0 {
+		return name[:slash+dot]
+	}
+	return name
+}
+
+// matchGlob reports whether name matches the glob pattern. Both are split
+// at "/"; a pattern segment matches one name segment as by path.Match,
+// except "**", which matches any number of them.
+func matchGlob(pattern, name string) bool {
+	segments := strings.Split(name, "/")
+	// matched[i] reports whether the pattern segments so far match
+	// segments[:i].
+	matched := make([]bool, len(segments)+1)
+	matched[0] = true
+	for _, p := range strings.Split(pattern, "/") {
+		next := make([]bool, len(segments)+1)
+		for i := range next {
+			switch {
+			case p == "**":
+				next[i] = matched[i] || (i > 0 && next[i-1])
+			case i > 0 && matched[i-1]:
+				ok, err := path.Match(p, segments[i-1])
+				next[i] = ok && err == nil
+			}
+		}
+		matched = next
+	}
+	return matched[len(segments)]
+}
@@ -0,0 +1,167 @@
+package timeout
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults for the zero fields of AdaptiveConfig.
+const (
+	DefaultHistoryFile  = ".timeout-history.json"
+	DefaultPercentile   = 99
+	DefaultSafetyFactor = 3
+	DefaultMinSamples   = 20
+	DefaultMaxSamples   = 100
+	DefaultFloor        = Duration(time.Second)
+)
+
+// AdaptiveConfig makes Run learn timeouts from how long tests actually take.
+// The durations of passing tests are recorded in a history file, and once a
+// test has MinSamples of them its timeout becomes the Percentile of its
+// recorded durations times SafetyFactor, but at least Floor. Tests matched
+// by a Rule keep the rule's timeout; learned timeouts only replace the
+// default.
+type AdaptiveConfig struct {
+	// History is the history file, relative to the configuration file.
+	History      string   `json:"history,omitempty"`
+	Percentile   float64  `json:"percentile,omitempty"`
+	SafetyFactor float64  `json:"safety_factor,omitempty"`
+	MinSamples   int      `json:"min_samples,omitempty"`
+	MaxSamples   int      `json:"max_samples,omitempty"`
+	Floor        Duration `json:"floor,omitempty"`
+}
+
+func (a AdaptiveConfig) withDefaults() AdaptiveConfig {
+	if a.History == "" {
+		a.History = DefaultHistoryFile
+	}
+	if a.Percentile <= 0 || a.Percentile > 100 {
+		a.Percentile = DefaultPercentile
+	}
+	if a.SafetyFactor <= 0 {
+		a.SafetyFactor = DefaultSafetyFactor
+	}
+	if a.MinSamples <= 0 {
+		a.MinSamples = DefaultMinSamples
+	}
+	if a.MaxSamples < a.MinSamples {
+		a.MaxSamples = max(DefaultMaxSamples, a.MinSamples)
+	}
+	if a.Floor <= 0 {
+		a.Floor = DefaultFloor
+	}
+	return a
+}
+
+// history is the file of recorded test durations, keyed by package and
+// test name. It is read once and rewritten after each recorded sample.
+type history struct {
+	path    string
+	mu      sync.Mutex
+	loaded  bool
+	samples map[string][]Duration
+}
+
+func newHistory(path string) *history {
+	return &history{path: path}
+}
+
+func historyKey(pkg, testName string) string {
+	return pkg + "." + testName
+}
+
+// load reads the history file on first use. The caller must hold mu.
+func (h *history) load() error {
+	if h.loaded {
+		return nil
+	}
+	samples, err := h.read()
+	if err != nil {
+		return err
+	}
+	h.samples, h.loaded = samples, true
+	return nil
+}
+
+func (h *history) read() (map[string][]Duration, error) {
+	samples := make(map[string][]Duration)
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return samples, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// record adds a sample, keeping the newest maxSamples per test. The file is
+// re-read first to pick up samples from test binaries running alongside,
+// then replaced atomically; two binaries recording at the same instant may
+// still lose one sample, which only delays learning.
+func (h *history) record(key string, d time.Duration, maxSamples int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples, err := h.read()
+	if err != nil {
+		return err
+	}
+	list := append(samples[key], Duration(d))
+	if len(list) > maxSamples {
+		list = list[len(list)-maxSamples:]
+	}
+	samples[key] = list
+
+	data, err := json.MarshalIndent(samples, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		return err
+	}
+	h.samples, h.loaded = samples, true
+	return nil
+}
+
+// learned returns the learned timeout for key, or false if there are too
+// few samples.
+func (h *history) learned(key string, a AdaptiveConfig) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.load(); err != nil {
+		return 0, false
+	}
+	samples := h.samples[key]
+	if len(samples) < a.MinSamples {
+		return 0, false
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	// Nearest-rank percentile.
+	rank := int(math.Ceil(a.Percentile / 100 * float64(len(sorted))))
+	p := time.Duration(sorted[max(rank, 1)-1])
+	return max(time.Duration(float64(p)*a.SafetyFactor), time.Duration(a.Floor)), true
+}
@@ -0,0 +1,155 @@
+package timeout
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DefaultLeakGrace is the default of LeakConfig.Grace.
+const DefaultLeakGrace = Duration(time.Second)
+
+// LeakConfig makes Run and ApplyTimeoutToTest check for goroutines a test
+// leaves running, such as the body of a test that timed out and ignored its
+// context. The goroutines running before the test are recorded, and those
+// started since that are still running Grace after the test ended, whether
+// it returned or timed out, are reported with their stacks, unless allowed
+// by Allow. Grace is real time, even with a fake clock installed.
+//
+// Goroutines of tests running in parallel look like leaks, so the check is
+// only reliable for tests that do not call t.Parallel.
+type LeakConfig struct {
+	Grace Duration `json:"grace,omitempty"`
+	// Allow lists known background goroutines. A goroutine is allowed if a
+	// function on its stack, or the function that created it, starts with
+	// one of these prefixes, such as "net/http.(*persistConn)".
+	Allow []string `json:"allow,omitempty"`
+}
+
+// CheckLeaks records the running goroutines and returns a function that
+// fails t if goroutines started since are still running after the grace
+// period of the configured LeakConfig, or DefaultLeakGrace. Goroutines
+// matching allow, or the configured allowlist, are ignored; see LeakConfig.
+//
+//	defer timeout.CheckLeaks(t)()
+func CheckLeaks(t testing.TB, allow ...string) func() {
+	t.Helper()
+	lc := newLeakChecker(configuredLeaks(), allow)
+	return func() {
+		t.Helper()
+		if leaked := lc.leaks(); len(leaked) > 0 {
+			t.Errorf("%s", lc.report(t.Name(), leaked))
+		}
+	}
+}
+
+// configuredLeaks returns the LeakConfig of the configuration, or nil.
+func configuredLeaks() *LeakConfig {
+	c, err := effectiveConfig()
+	if err != nil {
+		return nil
+	}
+	return c.Leaks
+}
+
+// leakChecker finds the goroutines started after it was created.
+type leakChecker struct {
+	before map[int]bool
+	grace  time.Duration
+	allow  []string
+}
+
+// newLeakChecker records the running goroutines. A nil c uses the defaults.
+func newLeakChecker(c *LeakConfig, allow []string) *leakChecker {
+	lc := &leakChecker{before: make(map[int]bool), grace: time.Duration(DefaultLeakGrace), allow: allow}
+	if c != nil {
+		if c.Grace > 0 {
+			lc.grace = time.Duration(c.Grace)
+		}
+		lc.allow = append(lc.allow, c.Allow...)
+	}
+	for _, g := range goroutines() {
+		lc.before[g.id] = true
+	}
+	return lc
+}
+
+// leaks waits up to the grace period for the goroutines started since lc
+// was created to exit, and returns those still running. Goroutines run in
+// real time whatever clock is installed, so the grace period is real time
+// too.
+func (lc *leakChecker) leaks() []goroutine {
+	deadline := time.Now().Add(lc.grace)
+	for {
+		var leaked []goroutine
+		for _, g := range goroutines() {
+			if !lc.before[g.id] && !lc.allowed(g) {
+				leaked = append(leaked, g)
+			}
+		}
+		if len(leaked) == 0 || !time.Now().Before(deadline) {
+			return leaked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (lc *leakChecker) allowed(g goroutine) bool {
+	for _, fn := range g.funcs {
+		for _, prefix := range lc.allow {
+			if strings.HasPrefix(fn, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (lc *leakChecker) report(testName string, leaked []goroutine) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "test %s leaked %d goroutine(s) still running %s after it ended:\n", testName, len(leaked), lc.grace)
+	for _, g := range leaked {
+		fmt.Fprintf(&b, "\n%s\n", g.stack)
+	}
+	return b.String()
+}
+
+// goroutine is a goroutine parsed from the output of runtime.Stack.
+type goroutine struct {
+	id int
+	// funcs are the functions on the stack, innermost first, followed by
+	// the function that created the goroutine.
+	funcs []string
+	stack string
+}
+
+// goroutines returns all running goroutines.
+func goroutines() []goroutine {
+	var gs []goroutine
+	for _, block := range strings.Split(string(allStacks()), "\n\n") {
+		header, frames, _ := strings.Cut(block, "\n")
+		g := goroutine{stack: block}
+		if _, err := fmt.Sscanf(header, "goroutine %d ", &g.id); err != nil {
+			continue
+		}
+		// Frames are a function line, e.g. "pkg.F(0x1, 0x2)", followed by
+		// an indented file line; the last is "created by pkg.G in goroutine 1".
+		for _, line := range strings.Split(frames, "\n") {
+			if line == "" || strings.HasPrefix(line, "\t") {
+				continue
+			}
+			if creator, ok := strings.CutPrefix(line, "created by "); ok {
+				creator, _, _ = strings.Cut(creator, " in goroutine ")
+				g.funcs = append(g.funcs, creator)
+				continue
+			}
+			if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+				line = line[:i]
+			}
+			g.funcs = append(g.funcs, line)
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
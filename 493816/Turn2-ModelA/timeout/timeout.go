@@ -2,50 +2,189 @@ package timeout
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"testing"
 	"time"
+
+	"clock"
 )
 
 var defaultTimeout = 5 * time.Second // Default timeout for tests
 
+// GracePeriod is how long Run waits, after cancelling the context of a test
+// that timed out, for the test body to return before failing the test. It is
+// real time, even with a fake clock installed, as nothing advances a fake
+// clock for a body that ignores its context.
+var GracePeriod = time.Second
+
 // SetDefaultTimeout allows setting the global default timeout
 func SetDefaultTimeout(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
 	defaultTimeout = d
 }
 
-// GetTimeout retrieves the timeout for a specific package or test.
+// GetTimeout retrieves the timeout for a specific test from the
+// configuration; see Config. Rules limited to a package do not apply, as
+// the package is not known.
 func GetTimeout(testName string) time.Duration {
-	// Example: you could use a map to store custom timeouts for specific tests
-	testTimeouts := map[string]time.Duration{
-		"TestLongRunning": 10 * time.Second,
-		"TestFast":        1 * time.Second,
-	}
-
-	// Return the specific timeout or default timeout
-	if timeout, ok := testTimeouts[testName]; ok {
-		return timeout
-	}
-
-	return defaultTimeout
+	return timeoutOrDefault("", testName)
 }
 
 // WithTestContext returns a context with a deadline for the given test name
 func WithTestContext(testName string) (context.Context, context.CancelFunc) {
-	timeout := GetTimeout(testName)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	timeout := timeoutOrDefault(callerPackage(1), testName)
+	ctx, cancel := clock.WithTimeout(currentClock(), context.Background(), timeout)
 	return ctx, cancel
 }
 
-// ApplyTimeoutToTest ensures the test doesn't exceed its timeout duration using a context
-func ApplyTimeoutToTest(testName string, testFunc func(context.Context)) {
-	ctx, cancel := WithTestContext(testName)
+// Run runs testFunc with the timeout configured for t.Name() in the calling
+// package; see Config. The context passed to testFunc is cancelled when the
+// timeout expires, so the body can wind down. On expiry the stacks of all
+// goroutines are captured and the test is failed with t.Fatalf once the
+// body has returned or GracePeriod has passed.
+//
+// With an adaptive configuration the wall-clock duration of a passing test
+// is recorded to learn its timeout from, unless a fake clock is installed;
+// see AdaptiveConfig. With a leak configuration the test fails if it leaves
+// goroutines running; see LeakConfig.
+//
+// testFunc runs on its own goroutine, so it must report failures with
+// t.Error rather than t.Fatal.
+func Run(t testing.TB, testFunc func(ctx context.Context)) {
+	t.Helper()
+
+	pkg := callerPackage(1)
+	timeout, err := GetTestTimeout(pkg, t.Name())
+	if err != nil {
+		t.Fatalf("test %s: %v", t.Name(), err)
+		return
+	}
+	// The leak check is deferred so that it also runs when the body times
+	// out, which is when it is most likely to leave goroutines behind, and
+	// after t.Fatalf.
+	if c := configuredLeaks(); c != nil {
+		leaks := newLeakChecker(c, nil)
+		defer func() {
+			if leaked := leaks.leaks(); len(leaked) > 0 {
+				t.Errorf("%s", leaks.report(t.Name(), leaked))
+			}
+		}()
+	}
+
+	clk := currentClock()
+	ctx, cancel := clock.WithTimeout(clk, context.Background(), timeout)
 	defer cancel()
+	ctx = withGrace(ctx, GracePeriod)
+
+	start := time.Now()
+	expired := func() string { return fmt.Sprintf("test %s timed out after %s", t.Name(), timeout) }
+	if !runBody(t, ctx, testFunc, expired) {
+		return
+	}
+	// Durations under a fake clock say nothing about how long the test
+	// takes, so only real ones are learned from.
+	if clk == clock.Real && !t.Failed() {
+		if err := recordDuration(pkg, t.Name(), time.Since(start)); err != nil {
+			t.Logf("timeout: recording duration of %s: %v", t.Name(), err)
+		}
+	}
+}
+
+// runBody runs testFunc on its own goroutine and reports whether it returned
+// before ctx was done. Otherwise the stacks of all goroutines are captured
+// and t is failed with the message returned by expired once the body has
+// returned or the grace period of ctx has passed.
+func runBody(t testing.TB, ctx context.Context, testFunc func(ctx context.Context), expired func() string) bool {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testFunc(ctx)
+	}()
+
+	select {
+	case <-done:
+		// A body that returns as its context expires, such as one
+		// sleeping on a FakeClock past the timeout, still timed out.
+		if ctx.Err() == nil {
+			return true
+		}
+	case <-ctx.Done():
+	}
+
+	stacks := allStacks()
+	select {
+	case <-done:
+	case <-time.After(graceOf(ctx)):
+	}
+	t.Fatalf("%s\n\ngoroutine stacks at expiry:\n%s", expired(), stacks)
+	return false
+}
 
+// ApplyTimeoutToTest ensures the test doesn't exceed its timeout duration using a context.
+// It returns an error if the timeout expired before testFunc returned and,
+// with a leak configuration, if goroutines were left running; see
+// LeakConfig. The leak check also runs after a timeout.
+func ApplyTimeoutToTest(testName string, testFunc func(context.Context)) error {
+	var leaks *leakChecker
+	if c := configuredLeaks(); c != nil {
+		leaks = newLeakChecker(c, nil)
+	}
+
+	timeout := timeoutOrDefault(callerPackage(1), testName)
+	ctx, cancel := clock.WithTimeout(currentClock(), context.Background(), timeout)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testFunc(ctx)
+	}()
+
+	var errs []error
 	select {
 	case <-ctx.Done():
 		// Timeout reached
-		fmt.Printf("Test %s timed out after %s\n", testName, ctx.Deadline().Sub(time.Now()))
-	case <-time.After(500 * time.Millisecond): // Example: Wait for test to complete
+		errs = append(errs, fmt.Errorf("test %s timed out after %s", testName, timeout))
+	case <-done:
 		// Test finished
 	}
+	cancel()
+	if leaks != nil {
+		if leaked := leaks.leaks(); len(leaked) > 0 {
+			errs = append(errs, errors.New(leaks.report(testName, leaked)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type graceKey struct{}
+
+// withGrace returns a context carrying the grace period runBody allows the
+// test body it is passed to.
+func withGrace(ctx context.Context, grace time.Duration) context.Context {
+	return context.WithValue(ctx, graceKey{}, grace)
+}
+
+// graceOf returns the grace period of ctx, GracePeriod if it has none.
+func graceOf(ctx context.Context) time.Duration {
+	if grace, ok := ctx.Value(graceKey{}).(time.Duration); ok {
+		return grace
+	}
+	return GracePeriod
+}
+
+// allStacks returns the stack traces of all goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
 }
@@ -0,0 +1,395 @@
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTB records how Run reports a test instead of failing the real one.
+type fakeTB struct {
+	testing.TB
+	name   string
+	failed string
+}
+
+func (f *fakeTB) Name() string        { return f.name }
+func (f *fakeTB) Helper()             {}
+func (f *fakeTB) Failed() bool        { return f.failed != "" }
+func (f *fakeTB) Logf(string, ...any) {}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	if f.failed != "" {
+		f.failed += "\n"
+	}
+	f.failed += fmt.Sprintf(format, args...)
+}
+
+// setConfig installs c for the rest of the test and then restores the
+// configuration in place before.
+func setConfig(t *testing.T, c *Config) {
+	t.Helper()
+	mu.RLock()
+	prev, prevErr := config, configErr
+	mu.RUnlock()
+	SetConfig(c)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		config, configErr = prev, prevErr
+	})
+}
+
+func TestRunFailsOnTimeout(t *testing.T) {
+	SetDefaultTimeout(50 * time.Millisecond)
+	defer SetDefaultTimeout(5 * time.Second)
+
+	tb := &fakeTB{name: "TestHangs"}
+	wound := make(chan struct{})
+	Run(tb, func(ctx context.Context) {
+		<-ctx.Done()
+		close(wound)
+	})
+
+	select {
+	case <-wound:
+	default:
+		t.Error("Expected the body to see its context cancelled")
+	}
+	if !strings.Contains(tb.failed, "test TestHangs timed out after 50ms") {
+		t.Errorf("Expected timeout failure, got %q", tb.failed)
+	}
+	if !strings.Contains(tb.failed, "goroutine ") || !strings.Contains(tb.failed, "TestRunFailsOnTimeout") {
+		t.Errorf("Expected goroutine stacks in failure, got %q", tb.failed)
+	}
+}
+
+func TestRunPasses(t *testing.T) {
+	tb := &fakeTB{name: "TestQuick"}
+	ran := false
+	Run(tb, func(ctx context.Context) {
+		ran = true
+	})
+	if !ran || tb.failed != "" {
+		t.Errorf("Expected body to run without failure, ran %v, failed %q", ran, tb.failed)
+	}
+}
+
+func TestConfigRules(t *testing.T) {
+	name := filepath.Join(t.TempDir(), ConfigFileName)
+	os.WriteFile(name, []byte(`{
+		"default": "3s",
+		"multiplier": 2,
+		"rules": [
+			{"package": "modela/pkg1", "test": "TestSlow/*", "timeout": "20s"},
+			{"package": "modela/**", "test": "TestSlow*", "timeout": "10s"},
+			{"test": "TestQuick", "timeout": "100ms"}
+		]
+	}`), 0o644)
+	c, err := LoadConfig(name)
+	if err != nil {
+		t.Fatal("Error loading config:", err)
+	}
+	setConfig(t, c)
+
+	for _, tc := range []struct {
+		pkg, test string
+		want      time.Duration
+	}{
+		{"modela/pkg1", "TestSlow/case_1", 40 * time.Second},
+		{"modela/pkg2", "TestSlowStartup", 20 * time.Second},
+		{"other/pkg", "TestSlowStartup", 6 * time.Second},
+		{"other/pkg", "TestQuick", 200 * time.Millisecond},
+	} {
+		got, err := GetTestTimeout(tc.pkg, tc.test)
+		if err != nil || got != tc.want {
+			t.Errorf("GetTestTimeout(%q, %q) = %s, %v, want %s", tc.pkg, tc.test, got, err, tc.want)
+		}
+	}
+
+	t.Setenv(EnvMultiplier, "0.5")
+	t.Setenv(EnvDefault, "8s")
+	if got, _ := GetTestTimeout("other/pkg", "TestOther"); got != 4*time.Second {
+		t.Errorf("Expected environment overrides to give 4s, got %s", got)
+	}
+	t.Setenv(EnvMultiplier, "fast")
+	if _, err := GetTestTimeout("other/pkg", "TestOther"); err == nil {
+		t.Error("Expected error for invalid multiplier")
+	}
+
+	if got := callerPackage(0); got != "modela/timeout" {
+		t.Errorf("Expected caller package modela/timeout, got %q", got)
+	}
+}
+
+func TestConfigYAML(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "timeouts.yaml")
+	os.WriteFile(name, []byte(`
+default: 3s
+multiplier: 2
+rules:
+  - package: modela/**
+    test: TestSlow*
+    timeout: 10s
+leaks:
+  grace: 250ms
+`), 0o644)
+	c, err := LoadConfig(name)
+	if err != nil {
+		t.Fatal("Error loading config:", err)
+	}
+	if c.Default != Duration(3*time.Second) || c.Multiplier != 2 || len(c.Rules) != 1 || c.Leaks == nil || c.Leaks.Grace != Duration(250*time.Millisecond) {
+		t.Errorf("Expected YAML keys read as in JSON, got %+v", c)
+	}
+	setConfig(t, c)
+	if got, _ := GetTestTimeout("modela/pkg2", "TestSlowStartup"); got != 20*time.Second {
+		t.Errorf("Expected rule from YAML to give 20s, got %s", got)
+	}
+
+	os.WriteFile(name, []byte("rules:\n  - test: TestX\n    timeout: 10\n"), 0o644)
+	if _, err := LoadConfig(name); err == nil || !strings.Contains(err.Error(), `like "10s"`) {
+		t.Errorf("Expected error for a duration without a unit, got %v", err)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"modela/pkg1", "modela/pkg1", true},
+		{"modela/*", "modela/pkg1", true},
+		{"modela/*", "modela/pkg1/sub", false},
+		{"modela/**", "modela/pkg1/sub", true},
+		{"modela/**", "modela", true},
+		{"**/sub", "modela/pkg1/sub", true},
+		{"**/sub", "modela/pkg1/other", false},
+		{"modela/**/sub", "modela/sub", true},
+		{"TestSlow/*", "TestSlow/case_1", true},
+		{"TestSlow/*", "TestSlow", false},
+		{"TestSlow*", "TestSlowStartup", true},
+		{"Test[", "Test[", false},
+	} {
+		if got := matchGlob(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAdaptiveTimeouts(t *testing.T) {
+	c := &Config{
+		Rules:    []Rule{{Test: "TestPinned", Timeout: Duration(time.Minute)}},
+		Adaptive: &AdaptiveConfig{History: filepath.Join(t.TempDir(), "history.json"), MinSamples: 3, SafetyFactor: 2, Floor: Duration(time.Millisecond)},
+	}
+	setConfig(t, c)
+
+	// Run records the duration of passing tests under their package.
+	Run(&fakeTB{name: "TestLearned"}, func(ctx context.Context) {})
+	samples, err := c.history.read()
+	if err != nil {
+		t.Fatal("Error reading history:", err)
+	}
+	if len(samples["modela/timeout.TestLearned"]) != 1 {
+		t.Fatalf("Expected one recorded sample, got %v", samples)
+	}
+
+	// Simulated durations are not learned from.
+	clk := NewFakeClock(time.Now())
+	SetClock(clk)
+	tb := &fakeTB{name: "TestSimulated"}
+	Run(tb, func(ctx context.Context) {
+		if err := clk.Sleep(ctx, time.Second); err != nil {
+			t.Errorf("Expected simulated work to fit the timeout, got %v", err)
+		}
+	})
+	SetClock(nil)
+	if tb.failed != "" {
+		t.Fatalf("Expected simulated test to pass, got %q", tb.failed)
+	}
+	if samples, _ := c.history.read(); len(samples["modela/timeout.TestSimulated"]) != 0 {
+		t.Errorf("Expected no sample recorded under a fake clock, got %v", samples)
+	}
+
+	// Until there are enough samples the default applies.
+	if got, _ := GetTestTimeout("modela/timeout", "TestLearned"); got != defaultTimeout {
+		t.Errorf("Expected default timeout before learning, got %s", got)
+	}
+	for _, d := range []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		recordDuration("modela/timeout", "TestLearned", d)
+		recordDuration("modela/timeout", "TestPinned", d)
+	}
+	if got, _ := GetTestTimeout("modela/timeout", "TestLearned"); got != 60*time.Millisecond {
+		t.Errorf("Expected p99 x 2 = 60ms, got %s", got)
+	}
+	if got, _ := GetTestTimeout("modela/timeout", "TestPinned"); got != time.Minute {
+		t.Errorf("Expected rule to win over learned timeout, got %s", got)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	setConfig(t, &Config{Rules: []Rule{{Test: "TestBudget/capped_by_rule", Timeout: Duration(30 * time.Millisecond)}}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	budget := NewBudget(ctx, 4)
+
+	expectTimeout := func(lo, hi time.Duration) func(*testing.T, context.Context) {
+		return func(t *testing.T, ctx context.Context) {
+			deadline, ok := ctx.Deadline()
+			if left := time.Until(deadline); !ok || left < lo || left > hi {
+				t.Errorf("Expected a timeout between %s and %s, got %s", lo, hi, left)
+			}
+		}
+	}
+	// The first of four subtests gets a quarter of the parent's time.
+	budget.Run(t, "share", expectTimeout(80*time.Millisecond, 100*time.Millisecond))
+	budget.Run(t, "capped_by_rule", expectTimeout(20*time.Millisecond, 30*time.Millisecond))
+	budget.SetCap(10 * time.Millisecond)
+	budget.Run(t, "capped", expectTimeout(time.Millisecond, 10*time.Millisecond))
+
+	cancel()
+	if budget.Run(t, "after_parent", func(t *testing.T, ctx context.Context) {
+		t.Error("Expected no subtest to start after the parent expired")
+	}) {
+		t.Error("Expected Run to report the skipped subtest as failed")
+	}
+}
+
+func TestCheckLeaks(t *testing.T) {
+	setConfig(t, &Config{Leaks: &LeakConfig{Grace: Duration(50 * time.Millisecond)}})
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	tb := &fakeTB{name: "TestLeaky"}
+	check := CheckLeaks(tb)
+	go func() { <-stop }()
+	go time.Sleep(10 * time.Millisecond) // exits within the grace period
+	check()
+	if !strings.Contains(tb.failed, "test TestLeaky leaked 1 goroutine(s)") || !strings.Contains(tb.failed, "TestCheckLeaks") {
+		t.Errorf("Expected one leaked goroutine with its stack, got %q", tb.failed)
+	}
+
+	tb = &fakeTB{name: "TestAllowed"}
+	check = CheckLeaks(tb, "modela/timeout.TestCheckLeaks")
+	go func() { <-stop }()
+	check()
+	if tb.failed != "" {
+		t.Errorf("Expected allowed goroutine to be ignored, got %q", tb.failed)
+	}
+
+	// Run checks for leaks with a leak configuration.
+	tb = &fakeTB{name: "TestRunLeaks"}
+	Run(tb, func(ctx context.Context) {
+		go func() { <-stop }()
+	})
+	if !strings.Contains(tb.failed, "test TestRunLeaks leaked 1 goroutine(s)") {
+		t.Errorf("Expected Run to report the leak, got %q", tb.failed)
+	}
+
+	// A body that times out is itself left running, and reported.
+	SetDefaultTimeout(20 * time.Millisecond)
+	defer SetDefaultTimeout(5 * time.Second)
+	tb = &fakeTB{name: "TestRunHangs"}
+	Run(tb, func(ctx context.Context) { <-stop })
+	if !strings.Contains(tb.failed, "test TestRunHangs timed out") || !strings.Contains(tb.failed, "test TestRunHangs leaked 1 goroutine(s)") {
+		t.Errorf("Expected Run to report the timeout and the hung body, got %q", tb.failed)
+	}
+
+	err := ApplyTimeoutToTest("TestApplyHangs", func(ctx context.Context) { <-stop })
+	if err == nil || !strings.Contains(err.Error(), "test TestApplyHangs timed out") || !strings.Contains(err.Error(), "test TestApplyHangs leaked 1 goroutine(s)") {
+		t.Errorf("Expected ApplyTimeoutToTest to return the timeout and the leak, got %v", err)
+	}
+}
+
+func TestCheckLeaksFakeClock(t *testing.T) {
+	setConfig(t, &Config{Leaks: &LeakConfig{Grace: Duration(50 * time.Millisecond)}})
+	SetClock(NewFakeClock(time.Now()))
+	defer SetClock(nil)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// The grace period passes in real time even though the fake clock
+	// never moves.
+	tb := &fakeTB{name: "TestLeakyFake"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(tb, func(ctx context.Context) {
+			go func() { <-stop }()
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the leak check to end under a fake clock")
+	}
+	if !strings.Contains(tb.failed, "test TestLeakyFake leaked 1 goroutine(s)") {
+		t.Errorf("Expected Run to report the leak, got %q", tb.failed)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clk := UseFakeClock(t)
+
+	// A test that times out after five simulated seconds fails without
+	// waiting for them.
+	start := time.Now()
+	tb := &fakeTB{name: "TestHangs"}
+	Run(tb, func(ctx context.Context) {
+		if err := clk.Sleep(ctx, time.Minute); err != context.DeadlineExceeded {
+			t.Errorf("Expected simulated work to run into the timeout, got %v", err)
+		}
+	})
+	if !strings.Contains(tb.failed, "test TestHangs timed out after 5s") {
+		t.Errorf("Expected timeout failure, got %q", tb.failed)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected simulated timeout to take no real time, took %s", elapsed)
+	}
+
+	tb = &fakeTB{name: "TestQuick"}
+	Run(tb, func(ctx context.Context) { clk.Sleep(ctx, 4*time.Second) })
+	if tb.failed != "" {
+		t.Errorf("Expected simulated work within the timeout to pass, got %q", tb.failed)
+	}
+}
+
+func TestFakeClockHungBody(t *testing.T) {
+	clk := UseFakeClock(t)
+	prev := GracePeriod
+	GracePeriod = 50 * time.Millisecond
+	defer func() { GracePeriod = prev }()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// The body runs past its timeout on the fake clock and then ignores
+	// its context, so only a real grace period ends Run.
+	tb := &fakeTB{name: "TestHungFake"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(tb, func(ctx context.Context) {
+			clk.Advance(time.Minute)
+			<-stop
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to end under a fake clock with a hung body")
+	}
+	if !strings.Contains(tb.failed, "test TestHungFake timed out after 5s") {
+		t.Errorf("Expected timeout failure, got %q", tb.failed)
+	}
+}
@@ -0,0 +1,218 @@
+// Package clock is the source of time for timeouts, so that tests of code
+// with timeouts can replace it with a Fake clock and run in milliseconds
+// whatever the timeouts are.
+package clock
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f on its own goroutine once d has passed, unless
+	// stop is called first. stop reports whether it prevented the call.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+	// Sleep waits for d to pass and returns nil, or the error of ctx if it
+	// is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Real is the real clock of package time.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WithTimeout is context.WithTimeout on the time of clk.
+func WithTimeout(clk Clock, parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if clk == Real {
+		return context.WithTimeout(parent, timeout)
+	}
+	deadline := clk.Now().Add(timeout)
+	if d, ok := parent.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	inner, cancel := context.WithCancelCause(parent)
+	stop := clk.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	ctx := &deadlineContext{Context: inner, deadline: deadline}
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// deadlineContext reports a deadline kept by a Clock other than the real
+// one, and context.DeadlineExceeded once it passes.
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *deadlineContext) Err() error {
+	if err := c.Context.Err(); err != nil {
+		if context.Cause(c.Context) == context.DeadlineExceeded {
+			return context.DeadlineExceeded
+		}
+		return err
+	}
+	return nil
+}
+
+// Fake is a Clock whose time only moves when Advance is called. Work that
+// takes time under the clock can be simulated with Sleep:
+//
+//	clk := clock.NewFake(time.Now())
+//	ctx, cancel := clock.WithTimeout(clk, context.Background(), 10*time.Second)
+//	defer cancel()
+//	err := clk.Sleep(ctx, 8*time.Second) // nil, at once
+//
+// Code that waits on the clock itself can be let through by another
+// goroutine calling WaitForTimers and then Advance.
+type Fake struct {
+	mu      sync.Mutex
+	changed sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	when time.Time
+	fire func(now time.Time)
+}
+
+// NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	c := &Fake{now: now}
+	c.changed.L = &c.mu
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by d.
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.add(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+// AfterFunc calls f on its own goroutine once the clock has been advanced
+// by d, unless stop is called first.
+func (c *Fake) AfterFunc(d time.Duration, f func()) func() bool {
+	t := c.add(d, func(time.Time) { go f() })
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		i := slices.Index(c.timers, t)
+		if i < 0 {
+			return false
+		}
+		c.timers = slices.Delete(c.timers, i, i+1)
+		c.changed.Broadcast()
+		return true
+	}
+}
+
+func (c *Fake) add(d time.Duration, fire func(time.Time)) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{when: c.now.Add(d), fire: fire}
+	if d <= 0 {
+		fire(c.now)
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.changed.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that become due
+// in the order of their times.
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		i := -1
+		for j, t := range c.timers {
+			if !t.when.After(end) && (i < 0 || t.when.Before(c.timers[i].when)) {
+				i = j
+			}
+		}
+		if i < 0 {
+			break
+		}
+		t := c.timers[i]
+		c.timers = slices.Delete(c.timers, i, i+1)
+		c.now = t.when
+		t.fire(c.now)
+	}
+	c.now = end
+	c.changed.Broadcast()
+}
+
+// Sleep simulates work or a wait taking d: it advances the clock by d and
+// returns nil, or the error of ctx if the work took it past its deadline on
+// the clock or ctx was done before.
+func (c *Fake) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Advance(d)
+	if deadline, ok := ctx.Deadline(); ok && !c.Now().Before(deadline) {
+		// Timers fire on their own goroutines; wait for the one
+		// cancelling ctx.
+		<-ctx.Done()
+	}
+	return ctx.Err()
+}
+
+// Timers returns the number of timers waiting for the clock to advance.
+func (c *Fake) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// WaitForTimers blocks until at least n timers are waiting for the clock to
+// advance, so a test can advance it once the code under test is waiting.
+func (c *Fake) WaitForTimers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
@@ -0,0 +1,68 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	clk := NewFake(time.Unix(0, 0))
+
+	first := clk.After(2 * time.Second)
+	stop := clk.AfterFunc(time.Second, func() { t.Error("Expected stopped timer not to fire") })
+	if !stop() {
+		t.Error("Expected stop to report the timer stopped")
+	}
+	clk.Advance(time.Second)
+	select {
+	case <-first:
+		t.Error("Expected timer not to fire before its time")
+	default:
+	}
+	clk.Advance(time.Second)
+	if now := <-first; !now.Equal(time.Unix(2, 0)) {
+		t.Errorf("Expected timer to fire at 2s, got %s", now)
+	}
+
+	ctx, cancel := WithTimeout(clk, context.Background(), time.Minute)
+	defer cancel()
+	if deadline, _ := ctx.Deadline(); !deadline.Equal(time.Unix(62, 0)) {
+		t.Errorf("Expected deadline at 62s, got %s", deadline)
+	}
+	clk.Advance(time.Minute)
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", ctx.Err())
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	clk := NewFake(time.Unix(0, 0))
+	ctx, cancel := WithTimeout(clk, context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := clk.Sleep(ctx, 8*time.Second); err != nil {
+		t.Errorf("Expected work within the deadline to succeed, got %v", err)
+	}
+	if err := clk.Sleep(ctx, 8*time.Second); err != context.DeadlineExceeded {
+		t.Errorf("Expected work past the deadline to fail, got %v", err)
+	}
+	if now := clk.Now(); !now.Equal(time.Unix(16, 0)) {
+		t.Errorf("Expected clock at 16s, got %s", now)
+	}
+	if err := clk.Sleep(ctx, time.Second); err != context.DeadlineExceeded {
+		t.Errorf("Expected no work after the deadline, got %v", err)
+	}
+}
+
+func TestRealSleep(t *testing.T) {
+	if err := Real.Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Expected the sleep to end, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Real.Sleep(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("Expected a done context to end the sleep, got %v", err)
+	}
+}
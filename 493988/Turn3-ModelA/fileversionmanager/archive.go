@@ -0,0 +1,444 @@
+package fileversionmanager
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An archive written by Export is a tar file holding, in order:
+//
+//	manifest.json     archiveManifest: every record and ref, in storage order
+//	objects/<hash>    the full content of each unpruned version, named by its
+//	                  SHA-256 and stored once however many versions share it
+//	SHA256SUMS        the SHA-256 of every other entry, in sha256sum format
+//
+// Records in the manifest carry no blob hash or delta base: those describe
+// how the exporting storage laid the data out, and Import re-encodes the
+// content for its own storage.
+const (
+	archiveFormat       = 1
+	archiveManifestName = "manifest.json"
+	archiveObjectsDir   = "objects/"
+	archiveSumsName     = "SHA256SUMS"
+)
+
+// ErrInvalidArchive is returned by Import for archives that are malformed or
+// fail their checksums.
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// ErrUnrelatedHistory is returned by Import for an archive whose history of
+// a file does not start from a version the file already has.
+var ErrUnrelatedHistory = errors.New("unrelated history")
+
+type archiveManifest struct {
+	Format   int       `json:"format"`
+	Exported time.Time `json:"exported"`
+	Records  []Record  `json:"records"`
+	Refs     []Ref     `json:"refs"`
+}
+
+// ImportResult reports what Import added.
+type ImportResult struct {
+	// Versions is the number of versions added.
+	Versions int
+	// Skipped is the number of versions left out because an identical
+	// version, with the same version hash, was already present.
+	Skipped int
+	// Refs is the number of tags and branches created.
+	Refs int
+}
+
+// Export writes the full history of every file, including metadata, tags
+// and branches, to w as a tar archive that Import can read back.
+func (v *FileVersionManager) Export(w io.Writer) error {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	manifest := archiveManifest{Format: archiveFormat, Exported: now, Records: []Record{}, Refs: []Ref{}}
+	fileNames := make([]string, 0, len(v.records))
+	for fileName := range v.records {
+		fileNames = append(fileNames, fileName)
+	}
+	slices.Sort(fileNames)
+	for _, fileName := range fileNames {
+		for _, rec := range v.records[fileName] {
+			rec.Hash, rec.Base = "", 0
+			manifest.Records = append(manifest.Records, rec)
+		}
+	}
+	refs, err := v.storage.LoadRefs()
+	if err != nil {
+		return fmt.Errorf("loading refs: %w", err)
+	}
+	manifest.Refs = append(manifest.Refs, refs...)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	var sums bytes.Buffer
+	writeEntry := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", hashBlob(data), name)
+		return nil
+	}
+
+	if err := writeEntry(archiveManifestName, manifestData); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	written := make(map[string]bool)
+	for _, fileName := range fileNames {
+		records := v.records[fileName]
+		for _, rec := range records {
+			if rec.Pruned || written[rec.ContentHash] {
+				continue
+			}
+			data, err := v.content(records, rec)
+			if err != nil {
+				return fmt.Errorf("loading data for file: %s, id: %d: %w", fileName, rec.ID, err)
+			}
+			if err := writeEntry(archiveObjectsDir+rec.ContentHash, data); err != nil {
+				return fmt.Errorf("writing data for file: %s, id: %d: %w", fileName, rec.ID, err)
+			}
+			written[rec.ContentHash] = true
+		}
+	}
+
+	header := &tar.Header{Name: archiveSumsName, Mode: 0o644, Size: int64(sums.Len()), ModTime: now, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(sums.Bytes()); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Import merges the history in an archive written by Export into v.
+//
+// The whole archive is read and checked first: every entry against
+// SHA256SUMS, and every version against its content hash and the hash chain.
+// Versions whose version hash is already present in the file are skipped, so
+// importing the same archive twice, or an archive that shares history with
+// v, does not duplicate anything. The remaining versions are appended after
+// the file's existing ones with new IDs, keeping their parent links, so IDs
+// never collide; branch heads end up at the newest imported version on each
+// branch. A tag that already exists on a different version is a conflict
+// and fails the import before anything is written.
+//
+// A file that already has versions only takes archived history that starts
+// from one of them. An archive with a history of its own for the file, such
+// as one exported from an unrelated manager, would add a second first
+// version and move the file's branches onto it, so it fails the import with
+// ErrUnrelatedHistory before anything is written.
+func (v *FileVersionManager) Import(r io.Reader) (ImportResult, error) {
+	var result ImportResult
+
+	manifest, objects, err := readArchive(r)
+	if err != nil {
+		return result, err
+	}
+
+	byFile := make(map[string][]Record)
+	var fileNames []string
+	for _, rec := range manifest.Records {
+		if _, ok := byFile[rec.FileName]; !ok {
+			fileNames = append(fileNames, rec.FileName)
+		}
+		byFile[rec.FileName] = append(byFile[rec.FileName], rec)
+	}
+	refsByFile := make(map[string][]Ref)
+	for _, ref := range manifest.Refs {
+		refsByFile[ref.FileName] = append(refsByFile[ref.FileName], ref)
+	}
+	for _, fileName := range fileNames {
+		if err := checkArchivedHistory(fileName, byFile[fileName], refsByFile[fileName], objects); err != nil {
+			return result, err
+		}
+	}
+	for fileName := range refsByFile {
+		if _, ok := byFile[fileName]; !ok {
+			return result, fmt.Errorf("%w: refs for file: %s, which has no versions", ErrInvalidArchive, fileName)
+		}
+	}
+
+	v.rwlock.Lock()
+	defer v.rwlock.Unlock()
+
+	plans := make([]importPlan, len(fileNames))
+	for i, fileName := range fileNames {
+		plan, err := v.planImport(fileName, byFile[fileName], refsByFile[fileName])
+		if err != nil {
+			return result, err
+		}
+		plans[i] = plan
+	}
+
+	for _, plan := range plans {
+		if err := v.applyImport(plan, objects, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// readArchive reads a whole archive and checks its entries against
+// SHA256SUMS. It returns the manifest and the objects by content hash.
+func readArchive(r io.Reader) (*archiveManifest, map[string][]byte, error) {
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, header.Name)
+		}
+		if _, ok := entries[header.Name]; ok {
+			return nil, nil, fmt.Errorf("%w: duplicate entry %s", ErrInvalidArchive, header.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: reading %s: %w", ErrInvalidArchive, header.Name, err)
+		}
+		entries[header.Name] = data
+	}
+
+	sums, ok := entries[archiveSumsName]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, archiveSumsName)
+	}
+	delete(entries, archiveSumsName)
+	listed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: malformed %s line: %q", ErrInvalidArchive, archiveSumsName, scanner.Text())
+		}
+		data, ok := entries[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: missing entry %s", ErrInvalidArchive, name)
+		}
+		if hashBlob(data) != sum {
+			return nil, nil, fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidArchive, name)
+		}
+		listed++
+	}
+	if listed != len(entries) {
+		return nil, nil, fmt.Errorf("%w: %d entries not covered by %s", ErrInvalidArchive, len(entries)-listed, archiveSumsName)
+	}
+
+	var manifest archiveManifest
+	manifestData, ok := entries[archiveManifestName]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, archiveManifestName)
+	}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("%w: decoding manifest: %w", ErrInvalidArchive, err)
+	}
+	if manifest.Format != archiveFormat {
+		return nil, nil, fmt.Errorf("%w: unsupported format %d", ErrInvalidArchive, manifest.Format)
+	}
+
+	objects := make(map[string][]byte)
+	for name, data := range entries {
+		hash, ok := strings.CutPrefix(name, archiveObjectsDir)
+		if !ok {
+			continue
+		}
+		if hashBlob(data) != hash {
+			return nil, nil, fmt.Errorf("%w: object %s does not match its name", ErrInvalidArchive, name)
+		}
+		objects[hash] = data
+	}
+	return &manifest, objects, nil
+}
+
+// checkArchivedHistory validates the records and refs of one file from an
+// archive: IDs are sequential, links point backwards, branches exist, and
+// every version matches its content and hash chain. Versions indexed before
+// hashes were recorded carry none and are checked by content only.
+func checkArchivedHistory(fileName string, records []Record, refs []Ref, objects map[string][]byte) error {
+	branches := map[string]bool{DefaultBranch: true}
+	for _, ref := range refs {
+		if ref.Target < 1 || ref.Target > len(records) {
+			return fmt.Errorf("%w: %s %s for file: %s points at missing version %d", ErrInvalidArchive, ref.Kind, ref.Name, fileName, ref.Target)
+		}
+		switch ref.Kind {
+		case RefTag:
+		case RefBranch:
+			branches[ref.Name] = true
+		default:
+			return fmt.Errorf("%w: ref %s for file: %s has unknown kind %q", ErrInvalidArchive, ref.Name, fileName, ref.Kind)
+		}
+	}
+
+	for i, rec := range records {
+		if rec.ID != i+1 {
+			return fmt.Errorf("%w: file: %s, expected id %d, got %d", ErrInvalidArchive, fileName, i+1, rec.ID)
+		}
+		if rec.Parent < 0 || rec.Parent >= rec.ID {
+			return fmt.Errorf("%w: file: %s, id %d has parent %d", ErrInvalidArchive, fileName, rec.ID, rec.Parent)
+		}
+		if !branches[rec.BranchName()] {
+			return fmt.Errorf("%w: file: %s, id %d is on unknown branch %q", ErrInvalidArchive, fileName, rec.ID, rec.BranchName())
+		}
+		if !rec.Pruned {
+			if _, ok := objects[rec.ContentHash]; !ok {
+				return fmt.Errorf("%w: file: %s, id %d has no content", ErrInvalidArchive, fileName, rec.ID)
+			}
+		}
+		if rec.VersionHash == "" {
+			continue
+		}
+		parentHash := ""
+		if rec.Parent != 0 {
+			parentHash = records[rec.Parent-1].VersionHash
+		}
+		if ComputeVersionHash(rec.ContentHash, rec.Timestamp, rec.UserID, rec.Description, parentHash) != rec.VersionHash {
+			return fmt.Errorf("%w: file: %s, id %d does not match its version hash", ErrInvalidArchive, fileName, rec.ID)
+		}
+	}
+	return nil
+}
+
+// importPlan is what Import will do to one file.
+type importPlan struct {
+	fileName string
+	// records are the archived records, with IDs and parents already
+	// remapped onto the file's ID space. skip marks those already present.
+	records []Record
+	skip    []bool
+	refs    []Ref
+}
+
+// planImport maps archived records and refs of a file onto its existing
+// history, failing on ref conflicts. Nothing is modified. The caller must
+// hold rwlock.
+func (v *FileVersionManager) planImport(fileName string, records []Record, refs []Ref) (importPlan, error) {
+	plan := importPlan{fileName: fileName}
+	existing := v.records[fileName]
+	present := make(map[string]int, len(existing))
+	for _, rec := range existing {
+		if rec.VersionHash != "" {
+			present[rec.VersionHash] = rec.ID
+		}
+	}
+
+	ids := make([]int, len(records)+1)
+	next := len(existing) + 1
+	for _, rec := range records {
+		if id, ok := present[rec.VersionHash]; ok {
+			ids[rec.ID] = id
+			plan.records = append(plan.records, rec)
+			plan.skip = append(plan.skip, true)
+			continue
+		}
+		if rec.Parent == 0 && len(existing) > 0 {
+			return plan, fmt.Errorf("%w for file: %s, archived id %d is a first version", ErrUnrelatedHistory, fileName, rec.ID)
+		}
+		ids[rec.ID] = next
+		rec.ID, rec.Parent = next, ids[rec.Parent]
+		plan.records = append(plan.records, rec)
+		plan.skip = append(plan.skip, false)
+		next++
+	}
+
+	for _, ref := range refs {
+		ref.Target = ids[ref.Target]
+		if id, ok := v.tags[fileName][ref.Name]; ok {
+			if ref.Kind == RefTag && id == ref.Target {
+				continue
+			}
+			return plan, fmt.Errorf("%w for file: %s, tag: %s", ErrTagExists, fileName, ref.Name)
+		}
+		if _, ok := v.heads[fileName][ref.Name]; ok || ref.Name == DefaultBranch {
+			if ref.Kind == RefBranch {
+				continue
+			}
+			return plan, fmt.Errorf("%w for file: %s, branch: %s", ErrBranchExists, fileName, ref.Name)
+		}
+		plan.refs = append(plan.refs, ref)
+	}
+	return plan, nil
+}
+
+// applyImport stores the versions and refs of a plan. Each ref is stored as
+// soon as the version it points at is, so a branch is always known before
+// the first version on it. The caller must hold rwlock.
+func (v *FileVersionManager) applyImport(plan importPlan, objects map[string][]byte, result *ImportResult) error {
+	fileName := plan.fileName
+	pending := plan.refs
+	addReady := func() error {
+		var rest []Ref
+		for _, ref := range pending {
+			if ref.Target > len(v.records[fileName]) {
+				rest = append(rest, ref)
+				continue
+			}
+			if err := v.addRef(ref); err != nil {
+				return err
+			}
+			result.Refs++
+		}
+		pending = rest
+		return nil
+	}
+
+	if err := addReady(); err != nil {
+		return err
+	}
+	for i, rec := range plan.records {
+		if plan.skip[i] {
+			result.Skipped++
+			continue
+		}
+		records := v.records[fileName]
+		rec.Hash, rec.Base = "", 0
+		if !rec.Pruned {
+			blob, base, err := v.encode(records, rec.Parent, objects[rec.ContentHash])
+			if err != nil {
+				return fmt.Errorf("encoding data for file: %s: %w", fileName, err)
+			}
+			if rec.Hash, err = v.storage.PutBlob(blob); err != nil {
+				return fmt.Errorf("storing data for file: %s: %w", fileName, err)
+			}
+			rec.Base = base
+		}
+		if err := v.storage.AppendRecord(rec); err != nil {
+			return fmt.Errorf("indexing version for file: %s: %w", fileName, err)
+		}
+		v.records[fileName] = append(records, rec)
+		v.refMap(v.heads, fileName)[rec.BranchName()] = rec.ID
+		v.index.add(rec)
+		v.publish(Event{Kind: EventVersionAdded, FileName: fileName, VersionID: rec.ID, Ref: rec.BranchName(), Hash: rec.VersionHash})
+		result.Versions++
+
+		if err := addReady(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
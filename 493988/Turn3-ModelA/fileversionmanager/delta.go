@@ -0,0 +1,153 @@
+package fileversionmanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A delta describes a target buffer in terms of a base buffer. It starts with
+// the uvarint length of the target, followed by a sequence of operations:
+//
+//	opCopy   uvarint(offset) uvarint(length)   copy length bytes of base from offset
+//	opInsert uvarint(length) bytes             append literal bytes
+//
+// Matches are found by hashing fixed-size blocks of the base and looking up a
+// rolling hash of the target, so the delta size is proportional to the size
+// of the edits rather than the size of the file.
+const (
+	opCopy   byte = 0
+	opInsert byte = 1
+
+	deltaBlockSize = 16
+	rollingBase    = 257
+)
+
+var errCorruptDelta = errors.New("corrupt delta")
+
+// encodeDelta returns a delta that rebuilds target from base.
+func encodeDelta(base, target []byte) []byte {
+	delta := binary.AppendUvarint(nil, uint64(len(target)))
+	if len(base) < deltaBlockSize || len(target) < deltaBlockSize {
+		return appendInsert(delta, target)
+	}
+
+	// Index the start of every aligned block of the base. The first
+	// occurrence wins so that copies prefer earlier offsets.
+	blocks := make(map[uint32]int, len(base)/deltaBlockSize)
+	for off := 0; off+deltaBlockSize <= len(base); off += deltaBlockSize {
+		h := blockHash(base[off : off+deltaBlockSize])
+		if _, ok := blocks[h]; !ok {
+			blocks[h] = off
+		}
+	}
+
+	// rollingBase^(deltaBlockSize-1), used to drop the leading byte.
+	var outFactor uint32 = 1
+	for i := 0; i < deltaBlockSize-1; i++ {
+		outFactor *= rollingBase
+	}
+
+	literalStart := 0
+	pos := 0
+	h := blockHash(target[:deltaBlockSize])
+	for pos+deltaBlockSize <= len(target) {
+		if off, ok := blocks[h]; ok && bytes.Equal(base[off:off+deltaBlockSize], target[pos:pos+deltaBlockSize]) {
+			// Extend the match backwards into pending literals and forwards
+			// as far as the buffers agree.
+			start, baseStart := pos, off
+			for start > literalStart && baseStart > 0 && target[start-1] == base[baseStart-1] {
+				start--
+				baseStart--
+			}
+			end, baseEnd := pos+deltaBlockSize, off+deltaBlockSize
+			for end < len(target) && baseEnd < len(base) && target[end] == base[baseEnd] {
+				end++
+				baseEnd++
+			}
+
+			delta = appendInsert(delta, target[literalStart:start])
+			delta = append(delta, opCopy)
+			delta = binary.AppendUvarint(delta, uint64(baseStart))
+			delta = binary.AppendUvarint(delta, uint64(end-start))
+
+			literalStart, pos = end, end
+			if pos+deltaBlockSize <= len(target) {
+				h = blockHash(target[pos : pos+deltaBlockSize])
+			}
+			continue
+		}
+
+		if pos+deltaBlockSize < len(target) {
+			h = (h-uint32(target[pos])*outFactor)*rollingBase + uint32(target[pos+deltaBlockSize])
+		}
+		pos++
+	}
+	return appendInsert(delta, target[literalStart:])
+}
+
+// applyDelta rebuilds the target described by delta on top of base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, errCorruptDelta
+	}
+	delta = delta[n:]
+	out := make([]byte, 0, size)
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case opCopy:
+			off, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+			if off+length > uint64(len(base)) {
+				return nil, fmt.Errorf("%w: copy [%d, %d) outside base of %d bytes", errCorruptDelta, off, off+length, len(base))
+			}
+			out = append(out, base[off:off+length]...)
+		case opInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 || uint64(len(delta)-n) < length {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+			out = append(out, delta[:length]...)
+			delta = delta[length:]
+		default:
+			return nil, fmt.Errorf("%w: unknown op %d", errCorruptDelta, op)
+		}
+	}
+
+	if uint64(len(out)) != size {
+		return nil, fmt.Errorf("%w: rebuilt %d bytes, expected %d", errCorruptDelta, len(out), size)
+	}
+	return out, nil
+}
+
+func appendInsert(delta, literal []byte) []byte {
+	if len(literal) == 0 {
+		return delta
+	}
+	delta = append(delta, opInsert)
+	delta = binary.AppendUvarint(delta, uint64(len(literal)))
+	return append(delta, literal...)
+}
+
+// blockHash is the polynomial hash that encodeDelta rolls over the target.
+func blockHash(block []byte) uint32 {
+	var h uint32
+	for _, b := range block {
+		h = h*rollingBase + uint32(b)
+	}
+	return h
+}
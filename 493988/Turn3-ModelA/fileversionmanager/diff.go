@@ -0,0 +1,331 @@
+package fileversionmanager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// DefaultContextLines is the number of unchanged lines shown around each
+// change in a unified diff when no WithContextLines option is given.
+const DefaultContextLines = 3
+
+// Diff describes what changed between two versions of a file.
+type Diff struct {
+	FileName string `json:"file"`
+	FromID   int    `json:"from"`
+	ToID     int    `json:"to"`
+	// Binary is set when either version is not a text version, in which case
+	// Changes is filled in instead of Unified.
+	Binary bool `json:"binary"`
+	// Unified is a unified diff of the two versions. It is empty when the
+	// versions are identical.
+	Unified string `json:"unified,omitempty"`
+	// Changes lists the byte ranges that differ between binary versions.
+	Changes []ByteChange `json:"changes,omitempty"`
+}
+
+// ByteChange records that From[FromOffset:FromOffset+FromLength] was
+// replaced by To[ToOffset:ToOffset+ToLength]. A zero length on either side
+// is a pure insertion or deletion.
+type ByteChange struct {
+	FromOffset int `json:"from_offset"`
+	FromLength int `json:"from_length"`
+	ToOffset   int `json:"to_offset"`
+	ToLength   int `json:"to_length"`
+}
+
+// DiffOption configures Diff.
+type DiffOption func(*diffConfig)
+
+type diffConfig struct {
+	contextLines int
+}
+
+// WithContextLines sets the number of unchanged lines shown around each
+// change in a unified diff.
+func WithContextLines(n int) DiffOption {
+	return func(c *diffConfig) {
+		c.contextLines = max(n, 0)
+	}
+}
+
+// Diff compares two versions of a file. Versions created by a
+// TextVersionFactory are compared line by line and rendered as a unified
+// diff; anything else is summarized as a list of changed byte ranges.
+func (v *FileVersionManager) Diff(fileName string, fromID, toID int, opts ...DiffOption) (*Diff, error) {
+	config := diffConfig{contextLines: DefaultContextLines}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	from, err := v.GetVersion(fileName, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := v.GetVersion(fileName, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{FileName: fileName, FromID: fromID, ToID: toID}
+	_, fromText := from.(*TextVersion)
+	_, toText := to.(*TextVersion)
+	if !fromText || !toText {
+		d.Binary = true
+		d.Changes = byteChanges(from.Data(), to.Data())
+		return d, nil
+	}
+
+	d.Unified = unifiedDiff(
+		fmt.Sprintf("a/%s@%d", fileName, fromID),
+		fmt.Sprintf("b/%s@%d", fileName, toID),
+		splitLines(string(from.Data())),
+		splitLines(string(to.Data())),
+		config.contextLines,
+	)
+	return d, nil
+}
+
+// splitLines splits text into lines, each keeping its trailing newline, so
+// that a missing newline at the end of the file shows up as a change.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+// edit is one step of an edit script turning a into b. A is the index into a
+// for equal and delete steps, B the index into b for equal and insert steps.
+type edit struct {
+	kind editKind
+	a, b int
+}
+
+// diffLines computes a shortest edit script from a to b using Myers'
+// O((N+M)D) algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] holds v[-d..d] as it was before round d, which is all the
+	// backtracking needs to recover the path.
+	var trace [][]int
+	var x, y int
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var script []edit
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d] < prev[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			script = append(script, edit{kind: editEqual, a: x, b: y})
+		}
+		if x == prevX {
+			script = append(script, edit{kind: editInsert, a: x, b: prevY})
+		} else {
+			script = append(script, edit{kind: editDelete, a: prevX, b: y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		script = append(script, edit{kind: editEqual, a: x, b: y})
+	}
+
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
+
+// unifiedDiff renders the difference between a and b in unified format with
+// the given number of context lines. It returns "" when a and b are equal.
+func unifiedDiff(fromName, toName string, a, b []string, context int) string {
+	script := diffLines(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(script); {
+		// Find the next change and grow the hunk until a run of more than
+		// 2*context unchanged lines separates it from the following one.
+		first := start
+		for first < len(script) && script[first].kind == editEqual {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		last := first
+		for i := first; i < len(script); i++ {
+			if script[i].kind != editEqual {
+				last = i
+			} else if i-last > 2*context {
+				break
+			}
+		}
+
+		lo := max(first-context, start)
+		hi := min(last+context+1, len(script))
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&out, a, b, script[lo:hi])
+		start = hi
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, a, b []string, hunk []edit) {
+	var fromStart, fromCount, toStart, toCount int
+	fromStart, toStart = -1, -1
+	for _, e := range hunk {
+		if e.kind != editInsert {
+			if fromStart < 0 {
+				fromStart = e.a
+			}
+			fromCount++
+		}
+		if e.kind != editDelete {
+			if toStart < 0 {
+				toStart = e.b
+			}
+			toCount++
+		}
+	}
+	// An empty side is reported at the line before the hunk.
+	if fromStart < 0 {
+		fromStart = hunk[0].a - 1
+	}
+	if toStart < 0 {
+		toStart = hunk[0].b - 1
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(fromStart, fromCount), hunkRange(toStart, toCount))
+
+	for _, e := range hunk {
+		var prefix, line string
+		switch e.kind {
+		case editEqual:
+			prefix, line = " ", a[e.a]
+		case editDelete:
+			prefix, line = "-", a[e.a]
+		case editInsert:
+			prefix, line = "+", b[e.b]
+		}
+		out.WriteString(prefix)
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// byteChanges summarizes how to differs from from. The common prefix and
+// suffix are trimmed first; in the remaining middle, the block matching of
+// the delta encoder decides which bytes of to are copied in order from from
+// and are therefore unchanged. Everything in between is reported as a change.
+func byteChanges(from, to []byte) []ByteChange {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	from, to = from[prefix:len(from)-suffix], to[prefix:len(to)-suffix]
+
+	delta := encodeDelta(from, to)
+	_, n := binary.Uvarint(delta)
+	delta = delta[n:]
+
+	var changes []ByteChange
+	fromPos, toPos := 0, 0
+	changeTo := 0
+	flush := func(fromEnd int) {
+		if fromEnd > fromPos || toPos > changeTo {
+			changes = append(changes, ByteChange{
+				FromOffset: prefix + fromPos,
+				FromLength: fromEnd - fromPos,
+				ToOffset:   prefix + changeTo,
+				ToLength:   toPos - changeTo,
+			})
+		}
+	}
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		first, n := binary.Uvarint(delta)
+		delta = delta[n:]
+		if op == opInsert {
+			delta = delta[first:]
+			toPos += int(first)
+			continue
+		}
+		length, n := binary.Uvarint(delta)
+		delta = delta[n:]
+
+		off := int(first)
+		if off < fromPos {
+			// Content moved from earlier in the file; report it as changed
+			// rather than reordering the summary.
+			toPos += int(length)
+			continue
+		}
+		flush(off)
+		fromPos = off + int(length)
+		toPos += int(length)
+		changeTo = toPos
+	}
+	flush(len(from))
+	return changes
+}
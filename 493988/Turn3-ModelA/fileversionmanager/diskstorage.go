@@ -0,0 +1,291 @@
+package fileversionmanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	indexFileName = "index.jsonl"
+	refsFileName  = "refs.jsonl"
+)
+
+// DiskStorage is a Storage rooted at a local directory.
+//
+// Blobs live under objects/<first two hash chars>/<hash> and are written to a
+// temporary file and renamed into place, so a blob is either fully present or
+// absent. The version index and the refs are JSON-lines journals that are
+// fsynced after every append; a partially written trailing line left behind
+// by a crash is discarded on the next load.
+type DiskStorage struct {
+	dir   string
+	index *journal
+	refs  *journal
+}
+
+// NewDiskStorage opens (creating if necessary) a DiskStorage in dir.
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0o755); err != nil {
+		return nil, err
+	}
+	index, err := openJournal(filepath.Join(dir, indexFileName))
+	if err != nil {
+		return nil, err
+	}
+	refs, err := openJournal(filepath.Join(dir, refsFileName))
+	if err != nil {
+		index.close()
+		return nil, err
+	}
+	return &DiskStorage{dir: dir, index: index, refs: refs}, nil
+}
+
+// Close releases the journal file handles.
+func (s *DiskStorage) Close() error {
+	return errors.Join(s.index.close(), s.refs.close())
+}
+
+func (s *DiskStorage) blobPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash[:2], hash)
+}
+
+// PutBlob writes data under its SHA-256 hash unless an identical blob is
+// already present.
+func (s *DiskStorage) PutBlob(data []byte) (string, error) {
+	hash := hashBlob(data)
+	path := s.blobPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// GetBlob reads the blob stored under hash and checks it against the hash.
+func (s *DiskStorage) GetBlob(hash string) ([]byte, error) {
+	if len(hash) < 2 {
+		return nil, fmt.Errorf("invalid blob hash: %q", hash)
+	}
+	data, err := os.ReadFile(s.blobPath(hash))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("blob not found: %s", hash)
+		}
+		return nil, err
+	}
+	if got := hashBlob(data); got != hash {
+		return nil, fmt.Errorf("blob %s is corrupt: content hashes to %s", hash, got)
+	}
+	return data, nil
+}
+
+// DeleteBlob removes the blob stored under hash. Deleting a missing blob is
+// not an error.
+func (s *DiskStorage) DeleteBlob(hash string) error {
+	if len(hash) < 2 {
+		return fmt.Errorf("invalid blob hash: %q", hash)
+	}
+	if err := os.Remove(s.blobPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// AppendRecord appends rec to the index journal and syncs it to disk.
+func (s *DiskStorage) AppendRecord(rec Record) error {
+	return s.index.append(rec)
+}
+
+// LoadRecords replays the index journal.
+func (s *DiskStorage) LoadRecords() ([]Record, error) {
+	var records []Record
+	err := s.index.load(func(line []byte) error {
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		records = append(records, rec)
+		return nil
+	})
+	return records, err
+}
+
+// ReplaceRecords atomically replaces the index journal with records.
+func (s *DiskStorage) ReplaceRecords(records []Record) error {
+	entries := make([]any, len(records))
+	for i, rec := range records {
+		entries[i] = rec
+	}
+	return s.index.replace(entries)
+}
+
+// AppendRef appends ref to the refs journal and syncs it to disk.
+func (s *DiskStorage) AppendRef(ref Ref) error {
+	return s.refs.append(ref)
+}
+
+// LoadRefs replays the refs journal.
+func (s *DiskStorage) LoadRefs() ([]Ref, error) {
+	var refs []Ref
+	err := s.refs.load(func(line []byte) error {
+		var ref Ref
+		if err := json.Unmarshal(line, &ref); err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+		return nil
+	})
+	return refs, err
+}
+
+// journal is an append-only file of JSON lines.
+type journal struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+func openJournal(path string) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{file: file}, nil
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// append writes v as one line and syncs the file.
+func (j *journal) append(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := j.file.Write(line); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// replace atomically swaps the journal for one holding entries: the new
+// content is written and synced to a temporary file that is then renamed
+// over the journal.
+func (j *journal) replace(entries []any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	path := j.file.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+// load calls decode for every complete line. A truncated final line, the
+// signature of a crash mid-append, is dropped and cut from the file so later
+// appends start on a clean line.
+func (j *journal) load(decode func(line []byte) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	content, err := io.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(content))
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// Unterminated trailing line: the append never completed.
+				return j.file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := decode(line); err != nil {
+			return fmt.Errorf("corrupt entry in %s at offset %d: %w", filepath.Base(j.file.Name()), offset, err)
+		}
+		offset += int64(len(line))
+	}
+}
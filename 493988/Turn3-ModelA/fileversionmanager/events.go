@@ -0,0 +1,142 @@
+package fileversionmanager
+
+import "sync/atomic"
+
+// DefaultEventBuffer is the number of events a Subscription buffers when no
+// WithEventBuffer option is given.
+const DefaultEventBuffer = 64
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	// EventVersionAdded reports a version added by AddVersion or Import.
+	EventVersionAdded EventKind = iota + 1
+	// EventTagged reports a tag created by Tag or Import.
+	EventTagged
+	// EventBranchCreated reports a branch created by CreateBranch or Import.
+	EventBranchCreated
+	// EventCompacted reports that Compact pruned versions of a file.
+	EventCompacted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventVersionAdded:
+		return "version added"
+	case EventTagged:
+		return "tagged"
+	case EventBranchCreated:
+		return "branch created"
+	case EventCompacted:
+		return "compacted"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change to the history of a file.
+type Event struct {
+	Kind     EventKind
+	FileName string
+	// VersionID is the version added, tagged or branched from. It is zero
+	// for EventCompacted.
+	VersionID int
+	// Ref is the branch the version was added on, or the tag or branch
+	// created.
+	Ref string
+	// Hash is the version hash of the added version.
+	Hash string
+	// PrunedVersions is the number of versions of the file that Compact
+	// pruned.
+	PrunedVersions int
+}
+
+// SubscribeOption configures Subscribe.
+type SubscribeOption func(*Subscription)
+
+// WithEventBuffer sets how many undelivered events a Subscription holds
+// before it starts dropping them.
+func WithEventBuffer(n int) SubscribeOption {
+	return func(s *Subscription) {
+		s.events = make(chan Event, max(n, 1))
+	}
+}
+
+// Subscription receives the events of the files matching a pattern.
+//
+// Events are delivered in the order the changes happened. Publishing never
+// waits for a subscriber: when a subscriber's buffer is full, new events for
+// it are dropped and counted in Dropped, and the change goes ahead. A
+// consumer that sees Dropped increase has missed events and should resync,
+// for example with ListVersions or Query.
+type Subscription struct {
+	manager *FileVersionManager
+	pattern string
+	events  chan Event
+	dropped atomic.Uint64
+	closed  bool
+}
+
+// Events returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription and closes its channel. Buffered events can
+// still be received.
+func (s *Subscription) Close() {
+	s.manager.subscribersMu.Lock()
+	defer s.manager.subscribersMu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.manager.subscribers, s)
+	close(s.events)
+}
+
+// Subscribe returns a Subscription to the events of every file whose name
+// matches pattern, using the syntax of RetentionPolicy.Pattern. A plain file
+// name subscribes to that file only and "**" to all files.
+func (v *FileVersionManager) Subscribe(pattern string, opts ...SubscribeOption) *Subscription {
+	s := &Subscription{manager: v, pattern: pattern}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.events == nil {
+		s.events = make(chan Event, DefaultEventBuffer)
+	}
+
+	v.subscribersMu.Lock()
+	defer v.subscribersMu.Unlock()
+	if v.subscribers == nil {
+		v.subscribers = make(map[*Subscription]struct{})
+	}
+	v.subscribers[s] = struct{}{}
+	return s
+}
+
+// publish delivers e to matching subscribers without blocking. Callers hold
+// rwlock for writing, which orders events the same way as the changes.
+func (v *FileVersionManager) publish(e Event) {
+	v.subscribersMu.Lock()
+	defer v.subscribersMu.Unlock()
+
+	for s := range v.subscribers {
+		if !matchGlob(s.pattern, e.FileName) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
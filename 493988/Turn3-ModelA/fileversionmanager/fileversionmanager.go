@@ -1,11 +1,24 @@
 package fileversionmanager
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
+// Errors returned by FileVersionManager, wrapped with details about the file
+// and version involved. Test for them with errors.Is.
+var (
+	ErrFileNotFound    = errors.New("no versions found")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrVersionPruned   = errors.New("version pruned")
+	ErrBranchNotFound  = errors.New("branch not found")
+	ErrRefNotFound     = errors.New("ref not found")
+	ErrTagExists       = errors.New("tag already exists")
+	ErrBranchExists    = errors.New("branch already exists")
+)
+
 // Version represents a single version of a file.
 type Version interface {
 	ID() int
@@ -13,46 +26,122 @@ type Version interface {
 	Timestamp() int64
 	UserID() string
 	Description() string
+	// Hash is the tamper-evident hash of the version, as computed by
+	// ComputeVersionHash from its content, metadata and ParentHash.
+	Hash() string
+	// ParentHash is the Hash of the version this one was derived from, or
+	// "" for the first version of a file.
+	ParentHash() string
 }
 
 // TextVersion implements the Version interface for text files.
 type TextVersion struct {
-	ID          int
-	Data        []byte
-	Timestamp   int64
-	UserID      string
-	Description string
+	id          int
+	data        []byte
+	timestamp   int64
+	userID      string
+	description string
+	hash        string
+	parentHash  string
 }
 
-func (tv *TextVersion) ID() int             { return tv.ID }
-func (tv *TextVersion) Data() []byte        { return tv.Data }
-func (tv *TextVersion) Timestamp() int64    { return tv.Timestamp }
-func (tv *TextVersion) UserID() string      { return tv.UserID }
-func (tv *TextVersion) Description() string { return tv.Description }
+func (tv *TextVersion) ID() int             { return tv.id }
+func (tv *TextVersion) Data() []byte        { return tv.data }
+func (tv *TextVersion) Timestamp() int64    { return tv.timestamp }
+func (tv *TextVersion) UserID() string      { return tv.userID }
+func (tv *TextVersion) Description() string { return tv.description }
+func (tv *TextVersion) Hash() string        { return tv.hash }
+func (tv *TextVersion) ParentHash() string  { return tv.parentHash }
 
 // VersionFactory is an interface that defines the factory method.
+// Implementations must derive the version's Hash with ComputeVersionHash so
+// that Verify can check it.
 type VersionFactory interface {
-	CreateVersion(id int, data []byte, timestamp int64, userID string, description string) Version
+	CreateVersion(id int, data []byte, timestamp int64, userID string, description string, parentHash string) Version
 }
 
 // TextVersionFactory implements VersionFactory for text files.
 type TextVersionFactory struct{}
 
-func (tvf *TextVersionFactory) CreateVersion(id int, data []byte, timestamp int64, userID string, description string) Version {
+func (tvf *TextVersionFactory) CreateVersion(id int, data []byte, timestamp int64, userID string, description string, parentHash string) Version {
 	return &TextVersion{
-		ID:          id,
-		Data:        data,
-		Timestamp:   timestamp,
-		UserID:      userID,
-		Description: description,
+		id:          id,
+		data:        data,
+		timestamp:   timestamp,
+		userID:      userID,
+		description: description,
+		hash:        ComputeVersionHash(hashBlob(data), timestamp, userID, description, parentHash),
+		parentHash:  parentHash,
+	}
+}
+
+// BinaryVersion implements the Version interface for binary files.
+type BinaryVersion struct {
+	id          int
+	data        []byte
+	timestamp   int64
+	userID      string
+	description string
+	hash        string
+	parentHash  string
+}
+
+func (bv *BinaryVersion) ID() int             { return bv.id }
+func (bv *BinaryVersion) Data() []byte        { return bv.data }
+func (bv *BinaryVersion) Timestamp() int64    { return bv.timestamp }
+func (bv *BinaryVersion) UserID() string      { return bv.userID }
+func (bv *BinaryVersion) Description() string { return bv.description }
+func (bv *BinaryVersion) Hash() string        { return bv.hash }
+func (bv *BinaryVersion) ParentHash() string  { return bv.parentHash }
+
+// BinaryVersionFactory implements VersionFactory for binary files.
+type BinaryVersionFactory struct{}
+
+func (bvf *BinaryVersionFactory) CreateVersion(id int, data []byte, timestamp int64, userID string, description string, parentHash string) Version {
+	return &BinaryVersion{
+		id:          id,
+		data:        data,
+		timestamp:   timestamp,
+		userID:      userID,
+		description: description,
+		hash:        ComputeVersionHash(hashBlob(data), timestamp, userID, description, parentHash),
+		parentHash:  parentHash,
 	}
 }
 
+// DefaultKeyframeInterval is the number of versions between two full
+// snapshots of a file when no WithKeyframeInterval option is given.
+const DefaultKeyframeInterval = 16
+
 // FileVersionManager manages versions for a file.
+//
+// Each file's history is stored as a chain of binary deltas against the
+// previous version, broken every keyframeInterval versions by a full
+// snapshot. Reconstructing any version therefore applies at most
+// keyframeInterval-1 deltas.
 type FileVersionManager struct {
-	versions       map[string][]Version
-	versionFactory VersionFactory
-	rwlock         sync.RWMutex
+	records          map[string][]Record
+	heads            map[string]map[string]int // file -> branch -> head version ID
+	tags             map[string]map[string]int // file -> tag -> version ID
+	storage          Storage
+	versionFactory   VersionFactory
+	keyframeInterval int
+	policies         []RetentionPolicy
+	index            versionIndex
+	rwlock           sync.RWMutex
+	subscribers      map[*Subscription]struct{}
+	subscribersMu    sync.Mutex
+}
+
+// Option configures a FileVersionManager.
+type Option func(*FileVersionManager)
+
+// WithKeyframeInterval sets how often a full snapshot is stored instead of a
+// delta. An interval of 1 or less stores every version in full.
+func WithKeyframeInterval(n int) Option {
+	return func(v *FileVersionManager) {
+		v.keyframeInterval = max(n, 1)
+	}
 }
 
 // Singleton instance of FileVersionManager.
@@ -61,63 +150,323 @@ var (
 	once     sync.Once
 )
 
-// GetInstance returns a single global instance of FileVersionManager.
+// GetInstance returns a single global instance of FileVersionManager backed
+// by in-memory storage.
 func GetInstance(versionFactory VersionFactory) *FileVersionManager {
 	once.Do(func() {
-		instance = &FileVersionManager{
-			versions:       make(map[string][]Version),
-			versionFactory: versionFactory,
-		}
+		// Loading from a fresh MemoryStorage cannot fail.
+		instance, _ = NewFileVersionManager(versionFactory, NewMemoryStorage())
 	})
 	return instance
 }
 
-// AddVersion adds a new version for a given file.
-func (v *FileVersionManager) AddVersion(fileName string, data []byte, userID string, description string) error {
+// NewFileVersionManager returns a FileVersionManager that persists its
+// versions in storage. Any history already present in storage is loaded, so
+// a manager backed by a DiskStorage picks up where the previous process left
+// off.
+func NewFileVersionManager(versionFactory VersionFactory, storage Storage, opts ...Option) (*FileVersionManager, error) {
+	records, err := storage.LoadRecords()
+	if err != nil {
+		return nil, fmt.Errorf("loading version index: %w", err)
+	}
+	refs, err := storage.LoadRefs()
+	if err != nil {
+		return nil, fmt.Errorf("loading refs: %w", err)
+	}
+
+	v := &FileVersionManager{
+		records:          make(map[string][]Record),
+		heads:            make(map[string]map[string]int),
+		tags:             make(map[string]map[string]int),
+		storage:          storage,
+		versionFactory:   versionFactory,
+		keyframeInterval: DefaultKeyframeInterval,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	for _, rec := range records {
+		if want := len(v.records[rec.FileName]) + 1; rec.ID != want {
+			return nil, fmt.Errorf("corrupt version index for file: %s, expected id %d, got %d", rec.FileName, want, rec.ID)
+		}
+		if rec.Parent == 0 && rec.ID > 1 && rec.VersionHash == "" {
+			// Indexes written before parents were recorded are linear,
+			// and have no hashes either.
+			rec.Parent = rec.ID - 1
+		}
+		if rec.Parent < 0 || rec.Parent >= rec.ID {
+			return nil, fmt.Errorf("corrupt version index for file: %s, id %d has parent %d", rec.FileName, rec.ID, rec.Parent)
+		}
+		if rec.Base < 0 || rec.Base >= rec.ID || (rec.Base > 0 && v.records[rec.FileName][rec.Base-1].Pruned) {
+			return nil, fmt.Errorf("corrupt version index for file: %s, id %d has delta base %d", rec.FileName, rec.ID, rec.Base)
+		}
+		v.records[rec.FileName] = append(v.records[rec.FileName], rec)
+	}
+
+	// Branch refs only record the fork point; heads then advance with every
+	// version added on the branch, in ID order.
+	for _, ref := range refs {
+		if ref.Target < 1 || ref.Target > len(v.records[ref.FileName]) {
+			return nil, fmt.Errorf("corrupt ref %s %q for file: %s, target %d does not exist", ref.Kind, ref.Name, ref.FileName, ref.Target)
+		}
+		switch ref.Kind {
+		case RefTag:
+			v.refMap(v.tags, ref.FileName)[ref.Name] = ref.Target
+		case RefBranch:
+			v.refMap(v.heads, ref.FileName)[ref.Name] = ref.Target
+		default:
+			return nil, fmt.Errorf("corrupt ref %q for file: %s, unknown kind %q", ref.Name, ref.FileName, ref.Kind)
+		}
+	}
+	for fileName, fileRecords := range v.records {
+		heads := v.refMap(v.heads, fileName)
+		for _, rec := range fileRecords {
+			branch := rec.BranchName()
+			if _, ok := heads[branch]; !ok && branch != DefaultBranch {
+				return nil, fmt.Errorf("corrupt version index for file: %s, id %d is on unknown branch %q", fileName, rec.ID, branch)
+			}
+			heads[branch] = rec.ID
+		}
+	}
+	v.index.rebuild(v.records)
+	return v, nil
+}
+
+// AddOption configures a single AddVersion call.
+type AddOption func(*addConfig)
+
+type addConfig struct {
+	branch      string
+	parent      int
+	checkParent bool
+}
+
+func newAddConfig(opts []AddOption) addConfig {
+	config := addConfig{branch: DefaultBranch}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// WithParent makes AddVersion succeed only if parentID is still the head of
+// the branch being added to, i.e. nobody else added a version since the
+// caller read it. A parentID of 0 expects the file to have no versions yet.
+// On mismatch AddVersion returns a *ConflictError.
+func WithParent(parentID int) AddOption {
+	return func(c *addConfig) {
+		c.parent = parentID
+		c.checkParent = true
+	}
+}
+
+// OnBranch adds the version on top of the named branch instead of
+// DefaultBranch. The branch must have been created with CreateBranch.
+func OnBranch(name string) AddOption {
+	return func(c *addConfig) {
+		c.branch = name
+	}
+}
+
+// ConflictError is returned by AddVersion when the version the caller based
+// its edit on is no longer the head of the branch. Merge can combine the
+// edit with the current head.
+type ConflictError struct {
+	FileName string
+	Branch   string
+	Expected int
+	Latest   int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict for file: %s, branch %s, expected parent %d, latest is %d", e.FileName, e.Branch, e.Expected, e.Latest)
+}
+
+// AddVersion adds a new version for a given file and returns it. The version
+// is added on top of the head of DefaultBranch unless OnBranch says
+// otherwise. Version IDs are unique per file across all branches.
+func (v *FileVersionManager) AddVersion(fileName string, data []byte, userID string, description string, opts ...AddOption) (Version, error) {
+	config := newAddConfig(opts)
+
 	v.rwlock.Lock()
 	defer v.rwlock.Unlock()
 
-	if v.versions[fileName] == nil {
-		v.versions[fileName] = make([]Version, 0)
+	records := v.records[fileName]
+	head, ok := v.heads[fileName][config.branch]
+	if !ok && config.branch != DefaultBranch {
+		return nil, fmt.Errorf("%w for file: %s, branch: %s", ErrBranchNotFound, fileName, config.branch)
+	}
+	if config.checkParent && config.parent != head {
+		return nil, &ConflictError{FileName: fileName, Branch: config.branch, Expected: config.parent, Latest: head}
+	}
+
+	blob, base, err := v.encode(records, head, data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding data for file: %s: %w", fileName, err)
 	}
 
-	timestamp := time.Now().Unix()
-	newVersionID := len(v.versions[fileName]) + 1
+	// The blob is written before the index entry, so a crash between the
+	// two leaves at worst an unreferenced blob, never a dangling record.
+	hash, err := v.storage.PutBlob(blob)
+	if err != nil {
+		return nil, fmt.Errorf("storing data for file: %s: %w", fileName, err)
+	}
 
-	newVersion := v.versionFactory.CreateVersion(
-		newVersionID,
-		data,
-		timestamp,
-		userID,
-		description,
-	)
+	id := len(records) + 1
+	version := v.versionFactory.CreateVersion(id, data, time.Now().Unix(), userID, description, v.parentHash(records, head))
+	rec := Record{
+		FileName:    fileName,
+		ID:          id,
+		Parent:      head,
+		Hash:        hash,
+		Base:        base,
+		ContentHash: hashBlob(data),
+		VersionHash: version.Hash(),
+		Timestamp:   version.Timestamp(),
+		UserID:      userID,
+		Description: description,
+	}
+	if config.branch != DefaultBranch {
+		rec.Branch = config.branch
+	}
+	if err := v.storage.AppendRecord(rec); err != nil {
+		return nil, fmt.Errorf("indexing version for file: %s: %w", fileName, err)
+	}
 
-	v.versions[fileName] = append(v.versions[fileName], newVersion)
-	return nil
+	v.records[fileName] = append(records, rec)
+	v.refMap(v.heads, fileName)[config.branch] = rec.ID
+	v.index.add(rec)
+	v.publish(Event{Kind: EventVersionAdded, FileName: fileName, VersionID: rec.ID, Ref: config.branch, Hash: rec.VersionHash})
+	return version, nil
 }
 
 // GetVersion retrieves a specific version for a given file by ID.
-func (v *FileVersionManager) GetVersion(fileName string, versionID int) (*Version, error) {
+func (v *FileVersionManager) GetVersion(fileName string, versionID int) (Version, error) {
 	v.rwlock.RLock()
 	defer v.rwlock.RUnlock()
 
-	for _, v := range v.versions[fileName] {
-		if v.ID() == versionID {
-			return v, nil
-		}
+	records := v.records[fileName]
+	if versionID < 1 || versionID > len(records) {
+		return nil, fmt.Errorf("%w for file: %s, id: %d", ErrVersionNotFound, fileName, versionID)
 	}
-	return nil, fmt.Errorf("version not found for file: %s, id: %d", fileName, versionID)
+	return v.load(records[versionID-1])
 }
 
-// ListVersions lists all versions for a given file.
-func (v *FileVersionManager) ListVersions(fileName string) ([]Version, error) {
+// ListVersions lists all versions for a given file in ID order, skipping
+// versions pruned by Compact. With InBranch, only the history leading up to
+// the head of that branch is listed, including the versions it was forked
+// from.
+func (v *FileVersionManager) ListVersions(fileName string, opts ...ListOption) ([]Version, error) {
+	var config listConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
 	v.rwlock.RLock()
 	defer v.rwlock.RUnlock()
 
-	if v.versions[fileName] == nil {
-		return nil, fmt.Errorf("no versions found for file: %s", fileName)
+	records := v.records[fileName]
+	if records == nil {
+		return nil, fmt.Errorf("%w for file: %s", ErrFileNotFound, fileName)
+	}
+	if config.branch != "" {
+		head, ok := v.heads[fileName][config.branch]
+		if !ok {
+			return nil, fmt.Errorf("%w for file: %s, branch: %s", ErrBranchNotFound, fileName, config.branch)
+		}
+		records = v.history(records, head)
+	}
+
+	versions := make([]Version, 0, len(records))
+	for _, rec := range records {
+		if rec.Pruned {
+			continue
+		}
+		version, err := v.load(rec)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// load materializes a Version from its index record. The caller must hold
+// rwlock.
+func (v *FileVersionManager) load(rec Record) (Version, error) {
+	if rec.Pruned {
+		return nil, fmt.Errorf("%w for file: %s, id: %d", ErrVersionPruned, rec.FileName, rec.ID)
+	}
+	records := v.records[rec.FileName]
+	data, err := v.content(records, rec)
+	if err != nil {
+		return nil, fmt.Errorf("loading data for file: %s, id: %d: %w", rec.FileName, rec.ID, err)
+	}
+	return v.versionFactory.CreateVersion(rec.ID, data, rec.Timestamp, rec.UserID, rec.Description, v.parentHash(records, rec.Parent)), nil
+}
+
+// parentHash returns the version hash of parent, or "" for no parent. The
+// caller must hold rwlock.
+func (v *FileVersionManager) parentHash(records []Record, parent int) string {
+	if parent == 0 {
+		return ""
+	}
+	return records[parent-1].VersionHash
+}
+
+// content reconstructs the full data of rec by starting from the nearest
+// keyframe and applying the deltas that lead up to it. The caller must hold
+// rwlock.
+func (v *FileVersionManager) content(records []Record, rec Record) ([]byte, error) {
+	chain := []Record{rec}
+	for rec.Base != 0 {
+		rec = records[rec.Base-1]
+		chain = append(chain, rec)
+	}
+
+	data, err := v.storage.GetBlob(chain[len(chain)-1].Hash)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(chain) - 2; i >= 0; i-- {
+		delta, err := v.storage.GetBlob(chain[i].Hash)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = applyDelta(data, delta); err != nil {
+			return nil, fmt.Errorf("applying delta for id %d: %w", chain[i].ID, err)
+		}
+	}
+	return data, nil
+}
+
+// encode decides how a new version with the given parent is stored. It
+// returns the blob to write and the ID of the version the blob is a delta
+// against, or 0 when the blob is a full snapshot. The caller must hold
+// rwlock.
+func (v *FileVersionManager) encode(records []Record, parent int, data []byte) ([]byte, int, error) {
+	if parent == 0 || records[parent-1].Pruned {
+		return data, 0, nil
+	}
+	prev := records[parent-1]
+
+	depth := 0
+	for rec := prev; rec.Base != 0; rec = records[rec.Base-1] {
+		depth++
+	}
+	if depth+1 >= v.keyframeInterval {
+		return data, 0, nil
 	}
 
-	// Return a copy of the slice to prevent data race
-	return append([]Version{}, v.versions[fileName]...), nil
+	prevData, err := v.content(records, prev)
+	if err != nil {
+		return nil, 0, err
+	}
+	delta := encodeDelta(prevData, data)
+	if len(delta) >= len(data) {
+		// Small or wholly rewritten content is cheaper to keep in full,
+		// which also shortens the chain for the versions that follow.
+		return data, 0, nil
+	}
+	return delta, prev.ID, nil
 }
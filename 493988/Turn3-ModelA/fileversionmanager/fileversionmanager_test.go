@@ -0,0 +1,985 @@
+package fileversionmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileVersionManager(t *testing.T) {
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+
+	// Test adding versions
+	_, err = fvManager.AddVersion("testfile.txt", []byte("Version 1 data"), "user123", "Initial version")
+	if err != nil {
+		t.Fatal("Error adding version 1:", err)
+	}
+
+	_, err = fvManager.AddVersion("testfile.txt", []byte("Version 2 data"), "user456", "Second version")
+	if err != nil {
+		t.Fatal("Error adding version 2:", err)
+	}
+
+	// Test retrieving versions
+	v1, err := fvManager.GetVersion("testfile.txt", 1)
+	if err != nil {
+		t.Fatal("Error retrieving version 1:", err)
+	}
+	if string(v1.Data()) != "Version 1 data" {
+		t.Errorf("Expected Version 1 data, got %s", string(v1.Data()))
+	}
+	if v1.UserID() != "user123" || v1.Description() != "Initial version" {
+		t.Errorf("Unexpected metadata for version 1: %s, %s", v1.UserID(), v1.Description())
+	}
+
+	// Test listing versions
+	versions, err := fvManager.ListVersions("testfile.txt")
+	if err != nil {
+		t.Fatal("Error listing versions:", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+	if string(versions[1].Data()) != "Version 2 data" {
+		t.Errorf("Expected Version 2 data, got %s", string(versions[1].Data()))
+	}
+
+	if _, err := fvManager.GetVersion("testfile.txt", 3); err == nil {
+		t.Error("Expected error for missing version 3")
+	}
+}
+
+func TestDiskStorageSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error opening storage:", err)
+	}
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	for _, add := range []struct{ file, data string }{
+		{"a.txt", "shared content"},
+		{"a.txt", "edited content"},
+		{"b.txt", "shared content"},
+	} {
+		if _, err := fvManager.AddVersion(add.file, []byte(add.data), "user123", "edit"); err != nil {
+			t.Fatal("Error adding version:", err)
+		}
+	}
+	storage.Close()
+
+	// Identical content across files is stored once.
+	blobs, err := filepath.Glob(filepath.Join(dir, "objects", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 2 {
+		t.Errorf("Expected 2 blobs, got %d", len(blobs))
+	}
+
+	storage, err = NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error reopening storage:", err)
+	}
+	defer storage.Close()
+	fvManager, err = NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error reloading manager:", err)
+	}
+
+	v2, err := fvManager.GetVersion("a.txt", 2)
+	if err != nil {
+		t.Fatal("Error retrieving version 2:", err)
+	}
+	if string(v2.Data()) != "edited content" {
+		t.Errorf("Expected edited content, got %s", string(v2.Data()))
+	}
+	versions, err := fvManager.ListVersions("b.txt")
+	if err != nil {
+		t.Fatal("Error listing versions:", err)
+	}
+	if len(versions) != 1 || string(versions[0].Data()) != "shared content" {
+		t.Errorf("Unexpected versions for b.txt: %v", versions)
+	}
+}
+
+func TestDiskStorageDiscardsTornAppend(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error opening storage:", err)
+	}
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	if _, err := fvManager.AddVersion("a.txt", []byte("v1"), "user123", "first"); err != nil {
+		t.Fatal("Error adding version:", err)
+	}
+	storage.Close()
+
+	// Simulate a crash halfway through writing the second index entry.
+	index, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.WriteString(`{"file":"a.txt","id":2,"ha`)
+	index.Close()
+
+	storage, err = NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error reopening storage:", err)
+	}
+	defer storage.Close()
+	fvManager, err = NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error reloading manager:", err)
+	}
+	if _, err := fvManager.AddVersion("a.txt", []byte("v2"), "user123", "second"); err != nil {
+		t.Fatal("Error adding version after recovery:", err)
+	}
+
+	versions, err := fvManager.ListVersions("a.txt")
+	if err != nil {
+		t.Fatal("Error listing versions:", err)
+	}
+	if len(versions) != 2 || string(versions[1].Data()) != "v2" {
+		t.Errorf("Expected 2 intact versions after recovery, got %d", len(versions))
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	target := append([]byte("prefix "), base[:500]...)
+	target = append(target, []byte(" inserted in the middle ")...)
+	target = append(target, base[520:]...)
+
+	delta := encodeDelta(base, target)
+	if len(delta) >= len(target)/4 {
+		t.Errorf("Expected a compact delta, got %d bytes for %d byte target", len(delta), len(target))
+	}
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal("Error applying delta:", err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Error("Delta did not reproduce the target")
+	}
+
+	if _, err := applyDelta(base[:100], delta); err == nil {
+		t.Error("Expected error applying delta to the wrong base")
+	}
+}
+
+func TestDeltaCompressedHistory(t *testing.T) {
+	storage := NewMemoryStorage()
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage, WithKeyframeInterval(4))
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+
+	var contents [][]byte
+	data := bytes.Repeat([]byte("a line of text that rarely changes\n"), 200)
+	for i := 0; i < 10; i++ {
+		data = append(append([]byte{}, data...), []byte(fmt.Sprintf("edit %d\n", i))...)
+		contents = append(contents, data)
+		if _, err := fvManager.AddVersion("big.txt", data, "user123", fmt.Sprintf("edit %d", i)); err != nil {
+			t.Fatal("Error adding version:", err)
+		}
+	}
+
+	records, _ := storage.LoadRecords()
+	stored, keyframes := 0, 0
+	for _, rec := range records {
+		blob, _ := storage.GetBlob(rec.Hash)
+		stored += len(blob)
+		if rec.Base == 0 {
+			keyframes++
+		}
+	}
+	if keyframes != 3 {
+		t.Errorf("Expected keyframes at versions 1, 5 and 9, got %d keyframes", keyframes)
+	}
+	if stored > 4*len(data) {
+		t.Errorf("Expected storage close to 3 snapshots, got %d bytes", stored)
+	}
+
+	for i, want := range contents {
+		version, err := fvManager.GetVersion("big.txt", i+1)
+		if err != nil {
+			t.Fatalf("Error retrieving version %d: %v", i+1, err)
+		}
+		if !bytes.Equal(version.Data(), want) {
+			t.Errorf("Version %d content mismatch", i+1)
+		}
+	}
+}
+
+func TestDiffText(t *testing.T) {
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	fvManager.AddVersion("notes.txt", []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\n"), "user123", "first")
+	fvManager.AddVersion("notes.txt", []byte("one\n2\nthree\nfour\nfive\nsix\nseven\neight"), "user123", "second")
+
+	diff, err := fvManager.Diff("notes.txt", 1, 2, WithContextLines(1))
+	if err != nil {
+		t.Fatal("Error diffing versions:", err)
+	}
+	want := `--- a/notes.txt@1
++++ b/notes.txt@2
+@@ -1,3 +1,3 @@
+ one
+-two
++2
+ three
+@@ -7 +7,2 @@
+ seven
++eight
+\ No newline at end of file
+`
+	if diff.Binary || diff.Unified != want {
+		t.Errorf("Unexpected diff:\n%s", diff.Unified)
+	}
+
+	same, err := fvManager.Diff("notes.txt", 2, 2)
+	if err != nil {
+		t.Fatal("Error diffing version with itself:", err)
+	}
+	if same.Unified != "" {
+		t.Errorf("Expected empty diff, got:\n%s", same.Unified)
+	}
+
+	if _, err := fvManager.Diff("notes.txt", 1, 3); err == nil {
+		t.Error("Expected error diffing against a missing version")
+	}
+}
+
+func TestDiffBinary(t *testing.T) {
+	fvManager, err := NewFileVersionManager(&BinaryVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	from := bytes.Repeat([]byte{0, 1, 2, 3, 4, 5, 6, 7}, 32)
+	to := append(append(append([]byte{}, from[:64]...), 0xff, 0xfe), from[80:]...)
+	fvManager.AddVersion("image.bin", from, "user123", "first")
+	fvManager.AddVersion("image.bin", to, "user123", "second")
+
+	diff, err := fvManager.Diff("image.bin", 1, 2)
+	if err != nil {
+		t.Fatal("Error diffing versions:", err)
+	}
+	want := []ByteChange{{FromOffset: 64, FromLength: 16, ToOffset: 64, ToLength: 2}}
+	if !diff.Binary || fmt.Sprint(diff.Changes) != fmt.Sprint(want) {
+		t.Errorf("Expected changes %v, got %v", want, diff.Changes)
+	}
+}
+
+func TestAddVersionConflict(t *testing.T) {
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := fvManager.AddVersion("shared.txt", []byte(fmt.Sprintf("v%d\n", i)), "user123", "edit", WithParent(i-1)); err != nil {
+			t.Fatalf("Error adding version %d: %v", i, err)
+		}
+	}
+
+	// Two users start from version 3; the second write must be rejected.
+	first, err := fvManager.AddVersion("shared.txt", []byte("first\n"), "alice", "edit", WithParent(3))
+	if err != nil {
+		t.Fatal("Error adding first concurrent version:", err)
+	}
+	if first.ID() != 4 {
+		t.Errorf("Expected version 4, got %d", first.ID())
+	}
+	_, err = fvManager.AddVersion("shared.txt", []byte("second\n"), "bob", "edit", WithParent(3))
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected ConflictError, got %v", err)
+	}
+	if conflict.Expected != 3 || conflict.Latest != 4 {
+		t.Errorf("Unexpected conflict details: %+v", conflict)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	base := "title\nalpha\nbeta\ngamma\ndelta\nepsilon\n"
+	fvManager.AddVersion("doc.txt", []byte(base), "user123", "base")
+	fvManager.AddVersion("doc.txt", []byte("TITLE\nalpha\nbeta\ngamma\ndelta\nepsilon\n"), "alice", "edit", WithParent(1))
+
+	// Bob edited a different region: clean merge.
+	result, err := fvManager.Merge("doc.txt", 1, []byte("title\nalpha\nbeta\ngamma\ndelta\nEPSILON\n"))
+	if err != nil {
+		t.Fatal("Error merging:", err)
+	}
+	if result.Conflicts != 0 || string(result.Data) != "TITLE\nalpha\nbeta\ngamma\ndelta\nEPSILON\n" {
+		t.Errorf("Unexpected clean merge result (%d conflicts):\n%s", result.Conflicts, result.Data)
+	}
+	if result.Parent != 2 {
+		t.Errorf("Expected merge parent 2, got %d", result.Parent)
+	}
+	if _, err := fvManager.AddVersion("doc.txt", result.Data, "bob", "merge", WithParent(result.Parent)); err != nil {
+		t.Fatal("Error adding merged version:", err)
+	}
+
+	// Carol edited the same line as Alice: conflict markers.
+	result, err = fvManager.Merge("doc.txt", 1, []byte("Title\nalpha\nbeta\ngamma\ndelta\nepsilon\n"))
+	if err != nil {
+		t.Fatal("Error merging:", err)
+	}
+	want := "<<<<<<< version 3\nTITLE\n=======\nTitle\n>>>>>>> proposed\nalpha\nbeta\ngamma\ndelta\nEPSILON\n"
+	if result.Conflicts != 1 || string(result.Data) != want {
+		t.Errorf("Unexpected conflicting merge result (%d conflicts):\n%s", result.Conflicts, result.Data)
+	}
+}
+
+func TestTagsAndBranches(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error opening storage:", err)
+	}
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+
+	fvManager.AddVersion("app.cfg", []byte("v1"), "user123", "first")
+	fvManager.AddVersion("app.cfg", []byte("v2"), "user123", "second")
+	if err := fvManager.Tag("app.cfg", "release-1", 2); err != nil {
+		t.Fatal("Error tagging version 2:", err)
+	}
+	if err := fvManager.Tag("app.cfg", "release-1", 1); err == nil {
+		t.Error("Expected error moving an existing tag")
+	}
+	if err := fvManager.CreateBranch("app.cfg", "hotfix", 1); err != nil {
+		t.Fatal("Error creating branch:", err)
+	}
+	fvManager.AddVersion("app.cfg", []byte("v3"), "user123", "third")
+	fix, err := fvManager.AddVersion("app.cfg", []byte("v1-fix"), "user456", "fix", OnBranch("hotfix"), WithParent(1))
+	if err != nil {
+		t.Fatal("Error adding version on branch:", err)
+	}
+	if fix.ID() != 4 {
+		t.Errorf("Expected branch version to get id 4, got %d", fix.ID())
+	}
+	storage.Close()
+
+	storage, err = NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error reopening storage:", err)
+	}
+	defer storage.Close()
+	fvManager, err = NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error reloading manager:", err)
+	}
+
+	released, err := fvManager.GetVersionByRef("app.cfg", "release-1")
+	if err != nil {
+		t.Fatal("Error resolving tag:", err)
+	}
+	if string(released.Data()) != "v2" {
+		t.Errorf("Expected tagged data v2, got %s", released.Data())
+	}
+
+	for branch, want := range map[string]string{DefaultBranch: "v1 v2 v3", "hotfix": "v1 v1-fix"} {
+		versions, err := fvManager.ListVersions("app.cfg", InBranch(branch))
+		if err != nil {
+			t.Fatalf("Error listing branch %s: %v", branch, err)
+		}
+		var got []string
+		for _, version := range versions {
+			got = append(got, string(version.Data()))
+		}
+		if fmt.Sprint(got) != "["+want+"]" {
+			t.Errorf("Branch %s: expected [%s], got %v", branch, want, got)
+		}
+	}
+
+	all, _ := fvManager.ListVersions("app.cfg")
+	if len(all) != 4 {
+		t.Errorf("Expected 4 versions across branches, got %d", len(all))
+	}
+	if heads := fvManager.Branches("app.cfg"); heads[DefaultBranch] != 3 || heads["hotfix"] != 4 {
+		t.Errorf("Unexpected branch heads: %v", heads)
+	}
+	if _, err := fvManager.AddVersion("app.cfg", []byte("x"), "user123", "x", OnBranch("missing")); err == nil {
+		t.Error("Expected error adding to a missing branch")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error opening storage:", err)
+	}
+	defer storage.Close()
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage,
+		WithKeyframeInterval(3),
+		WithRetentionPolicies(
+			RetentionPolicy{Pattern: "logs/**", KeepLast: 2},
+			RetentionPolicy{Pattern: "*.txt", KeepWithin: time.Hour},
+		),
+	)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+
+	data := bytes.Repeat([]byte("log line that repeats a lot\n"), 50)
+	for i := 1; i <= 8; i++ {
+		data = append(append([]byte{}, data...), []byte(fmt.Sprintf("entry %d\n", i))...)
+		fvManager.AddVersion("logs/app/today.log", data, "user123", fmt.Sprintf("entry %d", i))
+		fvManager.AddVersion("notes.txt", []byte(fmt.Sprintf("note %d", i)), "user123", "note")
+	}
+	if err := fvManager.Tag("logs/app/today.log", "keep-me", 3); err != nil {
+		t.Fatal("Error tagging:", err)
+	}
+
+	result, err := fvManager.Compact()
+	if err != nil {
+		t.Fatal("Error compacting:", err)
+	}
+	if result.PrunedVersions != 5 || result.DeletedBlobs == 0 {
+		t.Errorf("Unexpected compact result: %+v", result)
+	}
+
+	versions, err := fvManager.ListVersions("logs/app/today.log")
+	if err != nil {
+		t.Fatal("Error listing versions:", err)
+	}
+	var ids []int
+	for _, version := range versions {
+		ids = append(ids, version.ID())
+	}
+	if fmt.Sprint(ids) != "[3 7 8]" {
+		t.Errorf("Expected versions [3 7 8] to survive, got %v", ids)
+	}
+	if _, err := fvManager.GetVersion("logs/app/today.log", 5); err == nil {
+		t.Error("Expected error retrieving pruned version")
+	}
+	latest, err := fvManager.GetVersion("logs/app/today.log", 8)
+	if err != nil {
+		t.Fatal("Error retrieving latest version:", err)
+	}
+	if !bytes.Equal(latest.Data(), data) {
+		t.Error("Latest version content changed by Compact")
+	}
+	if versions, _ := fvManager.ListVersions("notes.txt"); len(versions) != 8 {
+		t.Errorf("Expected all 8 recent notes to survive, got %d", len(versions))
+	}
+
+	// IDs stay stable after a restart and new versions continue the sequence.
+	fvManager, err = NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error reloading manager:", err)
+	}
+	next, err := fvManager.AddVersion("logs/app/today.log", append(data, "entry 9\n"...), "user123", "entry 9")
+	if err != nil {
+		t.Fatal("Error adding version after compaction:", err)
+	}
+	if next.ID() != 9 {
+		t.Errorf("Expected id 9 after compaction, got %d", next.ID())
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.txt", "notes.txt", true},
+		{"*.txt", "dir/notes.txt", false},
+		{"**/*.txt", "notes.txt", true},
+		{"**/*.txt", "a/b/notes.txt", true},
+		{"logs/**", "logs/app/today.log", true},
+		{"logs/**", "other/today.log", false},
+	} {
+		if got := matchGlob(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyHashChain(t *testing.T) {
+	storage := NewMemoryStorage()
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	var prev Version
+	for i := 1; i <= 4; i++ {
+		version, err := fvManager.AddVersion("audit.txt", []byte(fmt.Sprintf("v%d", i)), "user123", fmt.Sprintf("edit %d", i))
+		if err != nil {
+			t.Fatal("Error adding version:", err)
+		}
+		if prev != nil && version.ParentHash() != prev.Hash() {
+			t.Errorf("Version %d does not link to its parent", i)
+		}
+		prev = version
+	}
+	if err := fvManager.Verify("audit.txt"); err != nil {
+		t.Fatal("Expected intact chain, got:", err)
+	}
+
+	// Rewrite the description of version 2 behind the manager's back.
+	records, _ := storage.LoadRecords()
+	records[1].Description = "nothing to see here"
+	storage.ReplaceRecords(records)
+	fvManager, err = NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error reloading manager:", err)
+	}
+
+	var chainErr *ChainError
+	if err := fvManager.Verify("audit.txt"); !errors.As(err, &chainErr) || chainErr.VersionID != 2 {
+		t.Errorf("Expected chain error at version 2, got %v", err)
+	}
+}
+
+func TestWatcherScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("notes.txt", "first")
+	write("docs/guide.txt", "guide")
+	write("build.log", "ignored")
+	write("tmp/scratch.txt", "ignored")
+
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	watcher := NewWatcher(fvManager, dir, WithInclude("**/*.txt"), WithExclude("tmp"), WithDebounce(time.Second))
+
+	start := time.Now()
+	if added, err := watcher.scan(start); err != nil || len(added) != 0 {
+		t.Fatalf("Expected no snapshots before debounce, got %d, %v", len(added), err)
+	}
+	added, err := watcher.scan(start.Add(2 * time.Second))
+	if err != nil {
+		t.Fatal("Error scanning:", err)
+	}
+	if len(added) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(added))
+	}
+	if _, err := fvManager.ListVersions("tmp/scratch.txt"); err == nil {
+		t.Error("Expected excluded directory to be skipped")
+	}
+	if _, err := fvManager.ListVersions("build.log"); err == nil {
+		t.Error("Expected non-included file to be skipped")
+	}
+
+	// A write keeps the file pending until it settles again.
+	write("notes.txt", "second version")
+	if added, _ := watcher.scan(start.Add(3 * time.Second)); len(added) != 0 {
+		t.Errorf("Expected change to be debounced, got %d snapshots", len(added))
+	}
+	added, err = watcher.scan(start.Add(5 * time.Second))
+	if err != nil || len(added) != 1 || string(added[0].Data()) != "second version" {
+		t.Fatalf("Expected snapshot of second version, got %v, %v", added, err)
+	}
+	if added[0].Description() != "Auto-snapshot: notes.txt modified (14 bytes)" {
+		t.Errorf("Unexpected description: %s", added[0].Description())
+	}
+
+	// Touching a file without changing it adds nothing, and neither does a
+	// fresh watcher over files that are already versioned.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "notes.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	watcher.scan(start.Add(6 * time.Second))
+	if added, _ := watcher.scan(start.Add(8 * time.Second)); len(added) != 0 {
+		t.Errorf("Expected touch to add nothing, got %d snapshots", len(added))
+	}
+	restarted := NewWatcher(fvManager, dir, WithInclude("**/*.txt"), WithExclude("tmp"), WithDebounce(0))
+	if added, _ := restarted.scan(start.Add(9 * time.Second)); len(added) != 0 {
+		t.Errorf("Expected restart to add nothing, got %d snapshots", len(added))
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	dir := t.TempDir()
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	watcher := NewWatcher(fvManager, dir, WithDebounce(10*time.Millisecond), WithPollInterval(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watcher.Run(ctx) }()
+
+	if err := os.WriteFile(filepath.Join(dir, "live.txt"), []byte("live"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if versions, err := fvManager.ListVersions("live.txt"); err == nil && len(versions) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for snapshot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Run to stop with context.Canceled, got %v", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	source, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage(), WithRetentionPolicies(RetentionPolicy{Pattern: "notes.txt", KeepLast: 2}))
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	for i := 1; i <= 4; i++ {
+		source.AddVersion("notes.txt", []byte(fmt.Sprintf("note %d\n", i)), "user123", fmt.Sprintf("note %d", i))
+	}
+	source.AddVersion("data/config.json", []byte(`{"a": 1}`), "user456", "config")
+	if err := source.Tag("notes.txt", "v3", 3); err != nil {
+		t.Fatal("Error tagging:", err)
+	}
+	if err := source.CreateBranch("notes.txt", "draft", 3); err != nil {
+		t.Fatal("Error branching:", err)
+	}
+	source.AddVersion("notes.txt", []byte("draft note\n"), "user123", "draft", OnBranch("draft"))
+	if _, err := source.Compact(); err != nil {
+		t.Fatal("Error compacting:", err)
+	}
+
+	var archive bytes.Buffer
+	if err := source.Export(&archive); err != nil {
+		t.Fatal("Error exporting:", err)
+	}
+
+	// A manager with unrelated history for the same file takes nothing.
+	target, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	target.AddVersion("notes.txt", []byte("local note\n"), "user789", "local")
+	if _, err := target.Import(bytes.NewReader(archive.Bytes())); !errors.Is(err, ErrUnrelatedHistory) {
+		t.Errorf("Expected unrelated history, got %v", err)
+	}
+	if versions, _ := target.ListVersions("notes.txt"); len(versions) != 1 {
+		t.Errorf("Expected failed import to add nothing, got %d versions", len(versions))
+	}
+
+	// Into a manager that only has other files.
+	target, err = NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	target.AddVersion("local.txt", []byte("local note\n"), "user789", "local")
+
+	result, err := target.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal("Error importing:", err)
+	}
+	if result.Versions != 6 || result.Skipped != 0 || result.Refs != 2 {
+		t.Errorf("Unexpected import result: %+v", result)
+	}
+	if err := target.Verify("notes.txt"); err != nil {
+		t.Error("Expected imported chain to verify:", err)
+	}
+	tagged, err := target.GetVersionByRef("notes.txt", "v3")
+	if err != nil {
+		t.Fatal("Error resolving imported tag:", err)
+	}
+	if tagged.ID() != 3 || string(tagged.Data()) != "note 3\n" {
+		t.Errorf("Expected tag v3 at id 3, got %d %q", tagged.ID(), tagged.Data())
+	}
+	draft, err := target.GetVersionByRef("notes.txt", "draft")
+	if err != nil || string(draft.Data()) != "draft note\n" || draft.ParentHash() != tagged.Hash() {
+		t.Errorf("Unexpected draft branch head: %v, %v", draft, err)
+	}
+	if _, err := target.GetVersion("notes.txt", 2); !errors.Is(err, ErrVersionPruned) {
+		t.Errorf("Expected pruned version to stay pruned, got %v", err)
+	}
+	if config, err := target.GetVersion("data/config.json", 1); err != nil || string(config.Data()) != `{"a": 1}` {
+		t.Errorf("Unexpected imported config: %v, %v", config, err)
+	}
+
+	// Importing again adds nothing.
+	result, err = target.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal("Error re-importing:", err)
+	}
+	if result.Versions != 0 || result.Skipped != 6 || result.Refs != 0 {
+		t.Errorf("Expected re-import to skip everything, got %+v", result)
+	}
+}
+
+func TestImportSurvivesRestart(t *testing.T) {
+	source, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	source.AddVersion("a.txt", []byte("one"), "user123", "one")
+	source.AddVersion("a.txt", []byte("two"), "user123", "two")
+	var first bytes.Buffer
+	if err := source.Export(&first); err != nil {
+		t.Fatal("Error exporting:", err)
+	}
+	source.AddVersion("a.txt", []byte("three"), "user123", "three")
+	source.AddVersion("a.txt", []byte("four"), "user123", "four")
+	var second bytes.Buffer
+	if err := source.Export(&second); err != nil {
+		t.Fatal("Error exporting:", err)
+	}
+
+	// The target shares the first two versions, then goes its own way on
+	// a branch, so the rest of the archive gets remapped IDs.
+	dir := t.TempDir()
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error opening storage:", err)
+	}
+	target, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	if _, err := target.Import(&first); err != nil {
+		t.Fatal("Error importing:", err)
+	}
+	if err := target.CreateBranch("a.txt", "local", 2); err != nil {
+		t.Fatal("Error branching:", err)
+	}
+	target.AddVersion("a.txt", []byte("local"), "user456", "local", OnBranch("local"))
+	result, err := target.Import(&second)
+	if err != nil {
+		t.Fatal("Error importing:", err)
+	}
+	if result.Versions != 2 || result.Skipped != 2 {
+		t.Errorf("Unexpected import result: %+v", result)
+	}
+	storage.Close()
+
+	storage, err = NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal("Error reopening storage:", err)
+	}
+	defer storage.Close()
+	reopened, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error reopening manager:", err)
+	}
+	if err := reopened.Verify("a.txt"); err != nil {
+		t.Error("Expected imported chain to verify after a restart:", err)
+	}
+	for ref, want := range map[string]string{DefaultBranch: "four", "local": "local"} {
+		head, err := reopened.GetVersionByRef("a.txt", ref)
+		if err != nil || string(head.Data()) != want {
+			t.Errorf("Expected %s at %q, got %v, %v", ref, want, head, err)
+		}
+	}
+	three, err := reopened.GetVersion("a.txt", 4)
+	if err != nil || string(three.Data()) != "three" {
+		t.Errorf("Expected three at remapped id 4, got %v, %v", three, err)
+	}
+}
+
+func TestImportRejectsBadArchives(t *testing.T) {
+	source, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	source.AddVersion("a.txt", []byte("one"), "user123", "one")
+	var early bytes.Buffer
+	if err := source.Export(&early); err != nil {
+		t.Fatal("Error exporting:", err)
+	}
+	source.AddVersion("a.txt", []byte("two"), "user123", "two")
+	source.Tag("a.txt", "release", 2)
+	var archive bytes.Buffer
+	if err := source.Export(&archive); err != nil {
+		t.Fatal("Error exporting:", err)
+	}
+
+	corrupt := bytes.Replace(archive.Bytes(), []byte("two"), []byte("TWO"), 1)
+	target, _ := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage())
+	if _, err := target.Import(bytes.NewReader(corrupt)); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("Expected invalid archive, got %v", err)
+	}
+
+	// A tag that points elsewhere in the target is a conflict, and nothing
+	// is imported.
+	if _, err := target.Import(&early); err != nil {
+		t.Fatal("Error importing:", err)
+	}
+	target.AddVersion("a.txt", []byte("mine"), "user456", "mine")
+	target.Tag("a.txt", "release", 1)
+	if _, err := target.Import(bytes.NewReader(archive.Bytes())); !errors.Is(err, ErrTagExists) {
+		t.Errorf("Expected tag conflict, got %v", err)
+	}
+	if versions, _ := target.ListVersions("a.txt"); len(versions) != 2 {
+		t.Errorf("Expected failed import to add nothing, got %d versions", len(versions))
+	}
+}
+
+func TestQuery(t *testing.T) {
+	// Seed the storage directly so that versions have known timestamps.
+	storage := NewMemoryStorage()
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	seed := []struct {
+		file, user, description string
+		hoursLater              int
+	}{
+		{"src/main.go", "alice", "Initial import", 0},
+		{"src/util.go", "bob", "Add helpers", 1},
+		{"src/main.go", "alice", "Fix typo", 2},
+		{"docs/readme.md", "carol", "Write docs", 3},
+		{"src/util.go", "alice", "fix off-by-one", 4},
+		{"src/main.go", "bob", "Refactor", 5},
+	}
+	ids := make(map[string]int)
+	for _, s := range seed {
+		hash, _ := storage.PutBlob([]byte(s.description))
+		ids[s.file]++
+		storage.AppendRecord(Record{
+			FileName:    s.file,
+			ID:          ids[s.file],
+			Hash:        hash,
+			ContentHash: hash,
+			Timestamp:   base.Add(time.Duration(s.hoursLater) * time.Hour).Unix(),
+			UserID:      s.user,
+			Description: s.description,
+		})
+	}
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, storage)
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+
+	describe := func(versions []VersionMetadata) string {
+		var out []string
+		for _, version := range versions {
+			out = append(out, fmt.Sprintf("%s@%d", version.FileName, version.ID))
+		}
+		return fmt.Sprint(out)
+	}
+	for _, tc := range []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"user", Query{UserID: "alice"}, "[src/main.go@1 src/main.go@2 src/util.go@2]"},
+		{"time range", Query{Since: base.Add(time.Hour), Until: base.Add(4 * time.Hour)}, "[src/util.go@1 src/main.go@2 docs/readme.md@1]"},
+		{"description", Query{DescriptionContains: "FIX"}, "[src/main.go@2 src/util.go@2]"},
+		{"glob", Query{FileGlob: "src/**", UserID: "bob", Descending: true}, "[src/main.go@3 src/util.go@1]"},
+		{"by file", Query{Sort: SortByFile, Since: base.Add(2 * time.Hour)}, "[docs/readme.md@1 src/main.go@2 src/main.go@3 src/util.go@2]"},
+		{"by user", Query{Sort: SortByUser, Descending: true, Limit: 2}, "[docs/readme.md@1 src/main.go@3]"},
+	} {
+		result, err := fvManager.Query(tc.query)
+		if err != nil {
+			t.Fatalf("%s: Error querying: %v", tc.name, err)
+		}
+		if got := describe(result.Versions); got != tc.want {
+			t.Errorf("%s: Expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+
+	// New versions are indexed as they are added, and pages chain together.
+	if _, err := fvManager.AddVersion("src/main.go", []byte("latest"), "alice", "Latest"); err != nil {
+		t.Fatal("Error adding version:", err)
+	}
+	var pages []string
+	query := Query{Limit: 3, Descending: true}
+	for {
+		result, err := fvManager.Query(query)
+		if err != nil {
+			t.Fatal("Error querying:", err)
+		}
+		pages = append(pages, describe(result.Versions))
+		if result.NextPageToken == "" {
+			break
+		}
+		query.PageToken = result.NextPageToken
+	}
+	want := "[[src/main.go@4 src/main.go@3 src/util.go@2] [docs/readme.md@1 src/main.go@2 src/util.go@1] [src/main.go@1]]"
+	if fmt.Sprint(pages) != want {
+		t.Errorf("Expected pages %s, got %v", want, pages)
+	}
+
+	query.Descending = false
+	if _, err := fvManager.Query(query); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("Expected token from a different order to be rejected, got %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	fvManager, err := NewFileVersionManager(&TextVersionFactory{}, NewMemoryStorage(), WithRetentionPolicies(RetentionPolicy{Pattern: "**", KeepLast: 1}))
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	src := fvManager.Subscribe("src/**")
+	defer src.Close()
+	small := fvManager.Subscribe("notes.txt", WithEventBuffer(1))
+
+	fvManager.AddVersion("src/main.go", []byte("one"), "user123", "one")
+	fvManager.AddVersion("notes.txt", []byte("one"), "user123", "one")
+	fvManager.AddVersion("src/main.go", []byte("two"), "user123", "two")
+	fvManager.AddVersion("notes.txt", []byte("two"), "user123", "two")
+	if err := fvManager.Tag("src/main.go", "v1", 1); err != nil {
+		t.Fatal("Error tagging:", err)
+	}
+	fvManager.AddVersion("src/main.go", []byte("three"), "user123", "three")
+	if _, err := fvManager.Compact(); err != nil {
+		t.Fatal("Error compacting:", err)
+	}
+
+	var got []string
+	for len(src.Events()) > 0 {
+		e := <-src.Events()
+		got = append(got, fmt.Sprintf("%s %s@%d %s %d", e.Kind, e.FileName, e.VersionID, e.Ref, e.PrunedVersions))
+	}
+	want := "[version added src/main.go@1 main 0 version added src/main.go@2 main 0 tagged src/main.go@1 v1 0 version added src/main.go@3 main 0 compacted src/main.go@0  1]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expected events %s, got %v", want, got)
+	}
+
+	// The slow subscriber keeps the first event and counts the rest.
+	if small.Dropped() != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", small.Dropped())
+	}
+	small.Close()
+	if e, ok := <-small.Events(); !ok || e.VersionID != 1 {
+		t.Errorf("Expected buffered event for version 1, got %+v", e)
+	}
+	if _, ok := <-small.Events(); ok {
+		t.Error("Expected channel to be closed")
+	}
+	small.Close()
+}
@@ -0,0 +1,218 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"modela/fileversionmanager"
+)
+
+// Client calls a Server.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the server at baseURL. A nil httpClient
+// uses http.DefaultClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient}
+}
+
+// APIError is returned for error responses from the server. Errors the
+// server reports with a known code unwrap to the matching fileversionmanager
+// error, so errors.Is(err, fileversionmanager.ErrVersionNotFound) works as
+// it does in-process. Conflicts are returned as a
+// *fileversionmanager.ConflictError instead.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+func (e *APIError) Unwrap() error {
+	for _, ec := range errorCodes {
+		if ec.code == e.Code {
+			return ec.err
+		}
+	}
+	return nil
+}
+
+// UploadOption configures Client.Upload.
+type UploadOption func(url.Values)
+
+// WithParent is the remote counterpart of fileversionmanager.WithParent.
+func WithParent(parentID int) UploadOption {
+	return func(q url.Values) {
+		q.Set("parent", strconv.Itoa(parentID))
+	}
+}
+
+// OnBranch is the remote counterpart of fileversionmanager.OnBranch.
+func OnBranch(name string) UploadOption {
+	return func(q url.Values) {
+		q.Set("branch", name)
+	}
+}
+
+// Upload adds data as a new version of fileName. The description is sent
+// in a header and so must not contain newlines.
+func (c *Client) Upload(ctx context.Context, fileName string, data []byte, userID, description string, opts ...UploadOption) (*VersionInfo, error) {
+	query := url.Values{}
+	for _, opt := range opts {
+		opt(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.fileURL(fileName, "versions", query), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(HeaderUserID, userID)
+	req.Header.Set(HeaderDescription, description)
+
+	var info VersionInfo
+	if err := c.doJSON(req, http.StatusCreated, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Content is a version fetched with Client.Fetch.
+type Content struct {
+	ID   int
+	ETag string
+	// NotModified is set when the server answered 304 Not Modified to the
+	// ETag passed to Fetch. Data is nil in that case.
+	NotModified bool
+	Data        []byte
+}
+
+// Fetch returns the content of the version ref names: a version ID, a tag or
+// a branch. If etag is non-empty and still matches, the content is not
+// transferred and the result has NotModified set.
+func (c *Client) Fetch(ctx context.Context, fileName, ref, etag string) (*Content, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.fileURL(fileName, "versions/"+url.PathEscape(ref), nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return nil, readError(resp)
+	}
+	content := &Content{ETag: resp.Header.Get("ETag"), NotModified: resp.StatusCode == http.StatusNotModified}
+	if content.ID, err = strconv.Atoi(resp.Header.Get(HeaderVersionID)); err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", HeaderVersionID, err)
+	}
+	if !content.NotModified {
+		if content.Data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// List returns the metadata of the versions of fileName. A non-empty branch
+// limits the list as fileversionmanager.InBranch does.
+func (c *Client) List(ctx context.Context, fileName, branch string) ([]VersionInfo, error) {
+	query := url.Values{}
+	if branch != "" {
+		query.Set("branch", branch)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.fileURL(fileName, "versions", query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []VersionInfo
+	if err := c.doJSON(req, http.StatusOK, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Diff compares two versions of fileName, showing contextLines unchanged
+// lines around each change of a text diff.
+func (c *Client) Diff(ctx context.Context, fileName string, fromID, toID, contextLines int) (*fileversionmanager.Diff, error) {
+	query := url.Values{}
+	query.Set("from", strconv.Itoa(fromID))
+	query.Set("to", strconv.Itoa(toID))
+	query.Set("context", strconv.Itoa(contextLines))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.fileURL(fileName, "diff", query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d fileversionmanager.Diff
+	if err := c.doJSON(req, http.StatusOK, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// fileURL returns the URL of an endpoint under /files/{file}/.
+func (c *Client) fileURL(fileName, endpoint string, query url.Values) string {
+	u := c.baseURL + "/files/" + url.PathEscape(fileName) + "/" + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// doJSON sends req and decodes a response with the wanted status into v.
+func (c *Client) doJSON(req *http.Request, want int, v any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return readError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding response from %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// readError turns an error response into an *APIError or, for version
+// conflicts, a *fileversionmanager.ConflictError.
+func readError(resp *http.Response) error {
+	var body ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+	if body.Code == CodeConflict {
+		return &fileversionmanager.ConflictError{
+			FileName: body.File,
+			Branch:   body.Branch,
+			Expected: body.Expected,
+			Latest:   body.Latest,
+		}
+	}
+	return &APIError{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Error}
+}
@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"modela/fileversionmanager"
+)
+
+func newTestClient(t *testing.T) (*Client, *fileversionmanager.FileVersionManager) {
+	t.Helper()
+	fvManager, err := fileversionmanager.NewFileVersionManager(&fileversionmanager.TextVersionFactory{}, fileversionmanager.NewMemoryStorage())
+	if err != nil {
+		t.Fatal("Error creating manager:", err)
+	}
+	server := httptest.NewServer(NewServer(fvManager))
+	t.Cleanup(server.Close)
+	return NewClient(server.URL, server.Client()), fvManager
+}
+
+func TestUploadFetchAndList(t *testing.T) {
+	client, fvManager := newTestClient(t)
+	ctx := context.Background()
+	fileName := "docs/readme.txt"
+
+	info, err := client.Upload(ctx, fileName, []byte("hello\n"), "alice", "Initial version")
+	if err != nil {
+		t.Fatal("Error uploading version 1:", err)
+	}
+	if info.ID != 1 || info.UserID != "alice" || info.Description != "Initial version" {
+		t.Errorf("Unexpected version info: %+v", info)
+	}
+	if _, err := client.Upload(ctx, fileName, []byte("hello\nworld\n"), "bob", "Second version", WithParent(1)); err != nil {
+		t.Fatal("Error uploading version 2:", err)
+	}
+
+	// The file name crossed the wire path-escaped; it must arrive intact.
+	v2, err := fvManager.GetVersion(fileName, 2)
+	if err != nil {
+		t.Fatal("Error retrieving version 2 in-process:", err)
+	}
+
+	content, err := client.Fetch(ctx, fileName, "2", "")
+	if err != nil {
+		t.Fatal("Error fetching version 2:", err)
+	}
+	if string(content.Data) != "hello\nworld\n" || content.ID != 2 {
+		t.Errorf("Expected version 2 content, got %d %q", content.ID, content.Data)
+	}
+	if content.ETag != `"`+v2.Hash()+`"` {
+		t.Errorf("Expected ETag of version hash, got %s", content.ETag)
+	}
+
+	cached, err := client.Fetch(ctx, fileName, "2", content.ETag)
+	if err != nil {
+		t.Fatal("Error fetching version 2 with ETag:", err)
+	}
+	if !cached.NotModified || cached.Data != nil {
+		t.Errorf("Expected not modified, got %+v", cached)
+	}
+	stale, err := client.Fetch(ctx, fileName, "1", content.ETag)
+	if err != nil {
+		t.Fatal("Error fetching version 1 with stale ETag:", err)
+	}
+	if stale.NotModified || string(stale.Data) != "hello\n" {
+		t.Errorf("Expected version 1 content, got %+v", stale)
+	}
+
+	infos, err := client.List(ctx, fileName, "")
+	if err != nil {
+		t.Fatal("Error listing versions:", err)
+	}
+	if len(infos) != 2 || infos[1].Hash != v2.Hash() || infos[1].ParentHash != infos[0].Hash {
+		t.Errorf("Unexpected version list: %+v", infos)
+	}
+
+	d, err := client.Diff(ctx, fileName, 1, 2, 0)
+	if err != nil {
+		t.Fatal("Error diffing versions:", err)
+	}
+	if !strings.Contains(d.Unified, "+world\n") || d.Binary {
+		t.Errorf("Unexpected diff: %+v", d)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	client, fvManager := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := client.Upload(ctx, "a.txt", []byte("one"), "alice", "one"); err != nil {
+		t.Fatal("Error uploading version 1:", err)
+	}
+	if _, err := client.Upload(ctx, "a.txt", []byte("two"), "alice", "two"); err != nil {
+		t.Fatal("Error uploading version 2:", err)
+	}
+
+	_, err := client.Upload(ctx, "a.txt", []byte("stale"), "bob", "stale", WithParent(1))
+	var conflict *fileversionmanager.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected ConflictError, got %v", err)
+	}
+	if conflict.FileName != "a.txt" || conflict.Expected != 1 || conflict.Latest != 2 {
+		t.Errorf("Unexpected conflict: %+v", conflict)
+	}
+
+	var apiErr *APIError
+	_, err = client.Fetch(ctx, "a.txt", "7", "")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 || !errors.Is(err, fileversionmanager.ErrRefNotFound) {
+		t.Errorf("Expected 404 ref not found, got %v", err)
+	}
+	_, err = client.List(ctx, "missing.txt", "")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 || !errors.Is(err, fileversionmanager.ErrFileNotFound) {
+		t.Errorf("Expected 404 file not found, got %v", err)
+	}
+	_, err = client.Upload(ctx, "a.txt", []byte("x"), "bob", "x", OnBranch("nope"))
+	if !errors.Is(err, fileversionmanager.ErrBranchNotFound) {
+		t.Errorf("Expected branch not found, got %v", err)
+	}
+
+	if err := fvManager.Tag("a.txt", "v1", 1); err != nil {
+		t.Fatal("Error tagging version 1:", err)
+	}
+	content, err := client.Fetch(ctx, "a.txt", "v1", "")
+	if err != nil {
+		t.Fatal("Error fetching tag v1:", err)
+	}
+	if string(content.Data) != "one" || content.ID != 1 {
+		t.Errorf("Expected tagged version 1, got %d %q", content.ID, content.Data)
+	}
+}
@@ -0,0 +1,293 @@
+// Package httpapi exposes a FileVersionManager over HTTP and provides a
+// client for it.
+//
+// The server serves these endpoints, where {file} is the path-escaped file
+// name (a "/" in the name is sent as %2F):
+//
+//	GET  /files/{file}/versions          list versions; ?branch= limits to a branch
+//	POST /files/{file}/versions          upload the request body as a new version
+//	GET  /files/{file}/versions/{ref}    raw content of a version, tag or branch head
+//	GET  /files/{file}/diff?from=&to=    diff between two versions; ?context= sets context lines
+//
+// Uploads take the author and description from the X-User-ID and
+// X-Description headers, and accept ?branch= and ?parent= to add to a
+// branch or to fail with 409 Conflict unless parent is still its head.
+//
+// Version content is served with the version hash as a strong ETag and
+// honors If-None-Match. Errors are JSON objects; see ErrorResponse.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"modela/fileversionmanager"
+)
+
+// MaxUploadSize is the largest version the server accepts.
+const MaxUploadSize = 64 << 20
+
+// Headers carrying version metadata.
+const (
+	HeaderUserID      = "X-User-ID"
+	HeaderDescription = "X-Description"
+	HeaderVersionID   = "X-Version-ID"
+)
+
+// VersionInfo is the metadata of a version as returned by the API.
+type VersionInfo struct {
+	ID          int    `json:"id"`
+	Timestamp   int64  `json:"timestamp"`
+	UserID      string `json:"user_id"`
+	Description string `json:"description"`
+	Size        int    `json:"size"`
+	Hash        string `json:"hash"`
+	ParentHash  string `json:"parent_hash,omitempty"`
+}
+
+func newVersionInfo(version fileversionmanager.Version) VersionInfo {
+	return VersionInfo{
+		ID:          version.ID(),
+		Timestamp:   version.Timestamp(),
+		UserID:      version.UserID(),
+		Description: version.Description(),
+		Size:        len(version.Data()),
+		Hash:        version.Hash(),
+		ParentHash:  version.ParentHash(),
+	}
+}
+
+// Error codes reported in ErrorResponse.Code. The client maps them back to
+// the corresponding fileversionmanager errors.
+const (
+	CodeBadRequest      = "bad_request"
+	CodeFileNotFound    = "file_not_found"
+	CodeVersionNotFound = "version_not_found"
+	CodeBranchNotFound  = "branch_not_found"
+	CodeRefNotFound     = "ref_not_found"
+	CodeVersionPruned   = "version_pruned"
+	CodeConflict        = "conflict"
+	CodeTagExists       = "tag_exists"
+	CodeBranchExists    = "branch_exists"
+	CodeTooLarge        = "too_large"
+	CodeInternal        = "internal"
+)
+
+// ErrorResponse is the body of every error response. File, Branch, Expected
+// and Latest are only set for CodeConflict.
+type ErrorResponse struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	File     string `json:"file,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Expected int    `json:"expected,omitempty"`
+	Latest   int    `json:"latest,omitempty"`
+}
+
+// errorCodes maps the manager's errors to a status and code. The first
+// match wins.
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{fileversionmanager.ErrFileNotFound, http.StatusNotFound, CodeFileNotFound},
+	{fileversionmanager.ErrVersionNotFound, http.StatusNotFound, CodeVersionNotFound},
+	{fileversionmanager.ErrBranchNotFound, http.StatusNotFound, CodeBranchNotFound},
+	{fileversionmanager.ErrRefNotFound, http.StatusNotFound, CodeRefNotFound},
+	{fileversionmanager.ErrVersionPruned, http.StatusGone, CodeVersionPruned},
+	{fileversionmanager.ErrTagExists, http.StatusConflict, CodeTagExists},
+	{fileversionmanager.ErrBranchExists, http.StatusConflict, CodeBranchExists},
+}
+
+// Server is an http.Handler serving a FileVersionManager.
+type Server struct {
+	manager *fileversionmanager.FileVersionManager
+	mux     *http.ServeMux
+}
+
+// NewServer returns a Server for manager.
+func NewServer(manager *fileversionmanager.FileVersionManager) *Server {
+	s := &Server{manager: manager, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /files/{file}/versions", s.listVersions)
+	s.mux.HandleFunc("POST /files/{file}/versions", s.uploadVersion)
+	s.mux.HandleFunc("GET /files/{file}/versions/{ref}", s.fetchVersion)
+	s.mux.HandleFunc("GET /files/{file}/diff", s.diff)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request) {
+	var opts []fileversionmanager.ListOption
+	if branch := r.URL.Query().Get("branch"); branch != "" {
+		opts = append(opts, fileversionmanager.InBranch(branch))
+	}
+
+	versions, err := s.manager.ListVersions(r.PathValue("file"), opts...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	infos := make([]VersionInfo, len(versions))
+	for i, version := range versions {
+		infos[i] = newVersionInfo(version)
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) uploadVersion(w http.ResponseWriter, r *http.Request) {
+	fileName := r.PathValue("file")
+	query := r.URL.Query()
+
+	var opts []fileversionmanager.AddOption
+	if branch := query.Get("branch"); branch != "" {
+		opts = append(opts, fileversionmanager.OnBranch(branch))
+	}
+	if parent := query.Get("parent"); parent != "" {
+		id, err := strconv.Atoi(parent)
+		if err != nil || id < 0 {
+			writeBadRequest(w, "invalid parent: %q", parent)
+			return
+		}
+		opts = append(opts, fileversionmanager.WithParent(id))
+	}
+
+	data, err := readBody(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error: fmt.Sprintf("version exceeds %d bytes", tooLarge.Limit),
+				Code:  CodeTooLarge,
+			})
+			return
+		}
+		writeBadRequest(w, "reading body: %v", err)
+		return
+	}
+
+	version, err := s.manager.AddVersion(fileName, data, r.Header.Get(HeaderUserID), r.Header.Get(HeaderDescription), opts...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(version.Hash()))
+	w.Header().Set("Location", r.URL.EscapedPath()+"/"+strconv.Itoa(version.ID()))
+	writeJSON(w, http.StatusCreated, newVersionInfo(version))
+}
+
+func (s *Server) fetchVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := s.manager.GetVersionByRef(r.PathValue("file"), r.PathValue("ref"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tag := etag(version.Hash())
+	w.Header().Set("ETag", tag)
+	w.Header().Set(HeaderVersionID, strconv.Itoa(version.ID()))
+	w.Header().Set("Last-Modified", time.Unix(version.Timestamp(), 0).UTC().Format(http.TimeFormat))
+	if etagMatches(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(version.Data())))
+	w.WriteHeader(http.StatusOK)
+	w.Write(version.Data())
+}
+
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fromID, err := strconv.Atoi(query.Get("from"))
+	if err != nil {
+		writeBadRequest(w, "invalid from: %q", query.Get("from"))
+		return
+	}
+	toID, err := strconv.Atoi(query.Get("to"))
+	if err != nil {
+		writeBadRequest(w, "invalid to: %q", query.Get("to"))
+		return
+	}
+	var opts []fileversionmanager.DiffOption
+	if context := query.Get("context"); context != "" {
+		n, err := strconv.Atoi(context)
+		if err != nil {
+			writeBadRequest(w, "invalid context: %q", context)
+			return
+		}
+		opts = append(opts, fileversionmanager.WithContextLines(n))
+	}
+
+	d, err := s.manager.Diff(r.PathValue("file"), fromID, toID, opts...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// etag quotes a version hash as a strong entity tag.
+func etag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists tag. Version
+// content never changes, so weak and strong tags compare equal.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError maps err to an HTTP status and writes it as an ErrorResponse.
+func writeError(w http.ResponseWriter, err error) {
+	var conflict *fileversionmanager.ConflictError
+	if errors.As(err, &conflict) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{
+			Error:    err.Error(),
+			Code:     CodeConflict,
+			File:     conflict.FileName,
+			Branch:   conflict.Branch,
+			Expected: conflict.Expected,
+			Latest:   conflict.Latest,
+		})
+		return
+	}
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			writeJSON(w, ec.status, ErrorResponse{Error: err.Error(), Code: ec.code})
+			return
+		}
+	}
+	writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error(), Code: CodeInternal})
+}
+
+func writeBadRequest(w http.ResponseWriter, format string, args ...any) {
+	writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf(format, args...), Code: CodeBadRequest})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
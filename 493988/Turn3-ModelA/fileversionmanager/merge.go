@@ -0,0 +1,184 @@
+package fileversionmanager
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// MergeResult is the outcome of a three-way merge.
+type MergeResult struct {
+	// Data is the merged content. Regions changed differently on both sides
+	// are wrapped in conflict markers.
+	Data []byte
+	// Conflicts is the number of conflicting regions in Data.
+	Conflicts int
+	// Parent is the version the merged content should be added on top of,
+	// for use with WithParent. It is 0 for results of Merge3.
+	Parent int
+}
+
+// Merge combines data, an edit the caller based on version baseID, with the
+// head of a branch. baseID is the common ancestor of both, which is what
+// AddVersion reports as ConflictError.Expected when the edit was rejected.
+// Only the OnBranch option is honored; it selects the branch to merge with.
+// The caller is expected to review the result and add it with
+// WithParent(result.Parent) on the same branch.
+func (v *FileVersionManager) Merge(fileName string, baseID int, data []byte, opts ...AddOption) (*MergeResult, error) {
+	config := newAddConfig(opts)
+
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	records := v.records[fileName]
+	if baseID < 0 || baseID > len(records) {
+		return nil, fmt.Errorf("%w for file: %s, id: %d", ErrVersionNotFound, fileName, baseID)
+	}
+	head, ok := v.heads[fileName][config.branch]
+	if !ok && config.branch != DefaultBranch {
+		return nil, fmt.Errorf("%w for file: %s, branch: %s", ErrBranchNotFound, fileName, config.branch)
+	}
+
+	var base, latest []byte
+	var err error
+	if baseID > 0 {
+		if base, err = v.content(records, records[baseID-1]); err != nil {
+			return nil, err
+		}
+	}
+	if head > 0 {
+		if latest, err = v.content(records, records[head-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	result := Merge3(base, latest, data, fmt.Sprintf("version %d", head), "proposed")
+	result.Parent = head
+	return result, nil
+}
+
+// Merge3 performs a line-based three-way merge of ours and theirs, both
+// derived from base. Changes made on only one side are applied; regions
+// changed on both sides, including changes to adjacent lines, are taken once
+// if identical and otherwise emitted between conflict markers labelled with
+// oursLabel and theirsLabel.
+func Merge3(base, ours, theirs []byte, oursLabel, theirsLabel string) *MergeResult {
+	baseLines := splitLines(string(base))
+	oursLines := splitLines(string(ours))
+	theirsLines := splitLines(string(theirs))
+	oursHunks := hunks(diffLines(baseLines, oursLines), oursLines)
+	theirsHunks := hunks(diffLines(baseLines, theirsLines), theirsLines)
+
+	result := &MergeResult{}
+	var out bytes.Buffer
+	pos := 0
+	for len(oursHunks) > 0 || len(theirsHunks) > 0 {
+		// Start a region at the earliest remaining hunk and keep absorbing
+		// hunks from either side that overlap or touch it.
+		var oursGroup, theirsGroup []hunk
+		var start, end int
+		if len(theirsHunks) == 0 || (len(oursHunks) > 0 && oursHunks[0].start <= theirsHunks[0].start) {
+			oursGroup, oursHunks = []hunk{oursHunks[0]}, oursHunks[1:]
+			start, end = oursGroup[0].start, oursGroup[0].end
+		} else {
+			theirsGroup, theirsHunks = []hunk{theirsHunks[0]}, theirsHunks[1:]
+			start, end = theirsGroup[0].start, theirsGroup[0].end
+		}
+		for {
+			if len(oursHunks) > 0 && oursHunks[0].start <= end {
+				oursGroup = append(oursGroup, oursHunks[0])
+				end = max(end, oursHunks[0].end)
+				oursHunks = oursHunks[1:]
+			} else if len(theirsHunks) > 0 && theirsHunks[0].start <= end {
+				theirsGroup = append(theirsGroup, theirsHunks[0])
+				end = max(end, theirsHunks[0].end)
+				theirsHunks = theirsHunks[1:]
+			} else {
+				break
+			}
+		}
+
+		writeLines(&out, baseLines[pos:start])
+		pos = end
+
+		oursRegion := applyHunks(baseLines, start, end, oursGroup)
+		theirsRegion := applyHunks(baseLines, start, end, theirsGroup)
+		switch {
+		case len(theirsGroup) == 0:
+			writeLines(&out, oursRegion)
+		case len(oursGroup) == 0, slices.Equal(oursRegion, theirsRegion):
+			writeLines(&out, theirsRegion)
+		default:
+			result.Conflicts++
+			fmt.Fprintf(&out, "<<<<<<< %s\n", oursLabel)
+			writeLines(&out, oursRegion)
+			terminateLine(&out)
+			out.WriteString("=======\n")
+			writeLines(&out, theirsRegion)
+			terminateLine(&out)
+			fmt.Fprintf(&out, ">>>>>>> %s\n", theirsLabel)
+		}
+	}
+	writeLines(&out, baseLines[pos:])
+
+	result.Data = out.Bytes()
+	return result
+}
+
+// hunk replaces base lines [start, end) with lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks groups the consecutive changes of an edit script from base to other
+// into hunks over base.
+func hunks(script []edit, other []string) []hunk {
+	var out []hunk
+	basePos := 0
+	for i := 0; i < len(script); {
+		if script[i].kind == editEqual {
+			basePos = script[i].a + 1
+			i++
+			continue
+		}
+		h := hunk{start: basePos, end: basePos}
+		for ; i < len(script) && script[i].kind != editEqual; i++ {
+			if script[i].kind == editDelete {
+				h.end = script[i].a + 1
+			} else {
+				h.lines = append(h.lines, other[script[i].b])
+			}
+		}
+		basePos = h.end
+		out = append(out, h)
+	}
+	return out
+}
+
+// applyHunks returns base[start:end] with the given hunks, which must lie
+// within that range, applied.
+func applyHunks(base []string, start, end int, group []hunk) []string {
+	var out []string
+	pos := start
+	for _, h := range group {
+		out = append(out, base[pos:h.start]...)
+		out = append(out, h.lines...)
+		pos = h.end
+	}
+	return append(out, base[pos:end]...)
+}
+
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// terminateLine makes sure a conflict marker starts on its own line even if
+// the preceding side ended without a newline.
+func terminateLine(out *bytes.Buffer) {
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package fileversionmanager
+
+import (
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+// notifier signals a Watcher that something under a watched directory
+// changed. The events themselves are not decoded: the next scan finds out
+// what happened.
+type notifier struct {
+	fd   int
+	file *os.File
+	wake chan struct{}
+}
+
+func newNotifier() (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking descriptor is read through the runtime poller, so
+	// closing the file unblocks the reader.
+	n := &notifier{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), wake: make(chan struct{}, 1)}
+	go n.read()
+	return n, nil
+}
+
+// watch adds dir to the watched set. inotify watches are not recursive, so
+// the Watcher calls this for every directory it walks; watching a directory
+// twice is harmless. Failures, such as running out of watches, leave the
+// directory to polling.
+func (n *notifier) watch(dir string) {
+	syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+}
+
+func (n *notifier) read() {
+	buf := make([]byte, 64<<10)
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *notifier) close() error {
+	return n.file.Close()
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package fileversionmanager
+
+import "errors"
+
+// notifier is only implemented on Linux; elsewhere a Watcher polls.
+type notifier struct {
+	wake chan struct{}
+}
+
+func newNotifier() (*notifier, error) {
+	return nil, errors.New("change notifications not supported")
+}
+
+func (n *notifier) watch(dir string) {}
+
+func (n *notifier) close() error { return nil }
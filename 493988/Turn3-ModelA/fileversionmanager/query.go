@@ -0,0 +1,279 @@
+package fileversionmanager
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultQueryLimit is the page size of Query when Query.Limit is not set.
+const DefaultQueryLimit = 100
+
+// ErrInvalidPageToken is returned by Query for a PageToken it did not issue
+// for the same sort order.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// SortField selects the order of Query results. Ties are broken by file name
+// and then version ID, so the order is total.
+type SortField int
+
+const (
+	// SortByTime orders by timestamp.
+	SortByTime SortField = iota
+	// SortByFile orders by file name and version ID.
+	SortByFile
+	// SortByUser orders by user ID and then timestamp.
+	SortByUser
+)
+
+// Query selects versions across all files. Zero fields do not filter.
+// Versions pruned by Compact are never returned.
+type Query struct {
+	// FileGlob matches file names with the syntax of RetentionPolicy.Pattern.
+	FileGlob string
+	// UserID matches the user ID exactly.
+	UserID string
+	// Since and Until bound the timestamp to [Since, Until).
+	Since time.Time
+	Until time.Time
+	// DescriptionContains matches a case-insensitive substring of the
+	// description.
+	DescriptionContains string
+
+	Sort       SortField
+	Descending bool
+
+	// Limit is the maximum number of versions returned, DefaultQueryLimit
+	// if zero.
+	Limit int
+	// PageToken continues from the QueryResult.NextPageToken of a previous
+	// query with the same filters and order.
+	PageToken string
+}
+
+// VersionMetadata describes a version without its content. GetVersion
+// loads the content.
+type VersionMetadata struct {
+	FileName    string
+	ID          int
+	Branch      string
+	Timestamp   int64
+	UserID      string
+	Description string
+	Hash        string
+}
+
+// QueryResult is a page of Query results.
+type QueryResult struct {
+	Versions []VersionMetadata
+	// NextPageToken fetches the next page, or is empty on the last page.
+	NextPageToken string
+}
+
+// Query returns the versions matching q.
+//
+// Versions are indexed by timestamp and by user, so a query filtering on
+// UserID or a time range only looks at the matching slice of the index, and
+// a query sorted by time stops as soon as a page is full. Other sort orders
+// sort the matches of the filters first.
+func (v *FileVersionManager) Query(q Query) (*QueryResult, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	needle := strings.ToLower(q.DescriptionContains)
+
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	var after *indexEntry
+	if q.PageToken != "" {
+		entry, err := v.decodePageToken(q)
+		if err != nil {
+			return nil, err
+		}
+		after = &entry
+	}
+
+	candidates := v.index.byTime
+	if q.UserID != "" {
+		candidates = v.index.byUser[q.UserID]
+	}
+	lo, hi := 0, len(candidates)
+	if !q.Since.IsZero() {
+		since := q.Since.Unix()
+		lo = sort.Search(len(candidates), func(i int) bool { return candidates[i].timestamp >= since })
+	}
+	if !q.Until.IsZero() {
+		until := q.Until.Unix()
+		hi = sort.Search(len(candidates), func(i int) bool { return candidates[i].timestamp >= until })
+	}
+	candidates = candidates[lo:max(lo, hi)]
+
+	matches := func(e indexEntry) bool {
+		rec := v.records[e.fileName][e.id-1]
+		return !rec.Pruned &&
+			(q.FileGlob == "" || matchGlob(q.FileGlob, rec.FileName)) &&
+			(needle == "" || strings.Contains(strings.ToLower(rec.Description), needle))
+	}
+	compare := v.compareFunc(q.Sort)
+	if q.Sort != SortByTime {
+		var matched []indexEntry
+		for _, e := range candidates {
+			if matches(e) {
+				matched = append(matched, e)
+			}
+		}
+		slices.SortFunc(matched, compare)
+		candidates = matched
+	}
+
+	// Walk candidates in the requested direction, starting after the
+	// cursor, until one more match than fits on the page is seen.
+	result := &QueryResult{}
+	start, end, step := 0, len(candidates), 1
+	if q.Descending {
+		start, end, step = len(candidates)-1, -1, -1
+	}
+	if after != nil {
+		pos := sort.Search(len(candidates), func(i int) bool { return compare(candidates[i], *after) > 0 })
+		if q.Descending {
+			pos = sort.Search(len(candidates), func(i int) bool { return compare(candidates[i], *after) >= 0 }) - 1
+		}
+		start = pos
+	}
+	var last indexEntry
+	for i := start; i != end; i += step {
+		e := candidates[i]
+		if !matches(e) {
+			continue
+		}
+		if len(result.Versions) == limit {
+			result.NextPageToken = encodePageToken(q, last)
+			break
+		}
+		rec := v.records[e.fileName][e.id-1]
+		result.Versions = append(result.Versions, VersionMetadata{
+			FileName:    rec.FileName,
+			ID:          rec.ID,
+			Branch:      rec.BranchName(),
+			Timestamp:   rec.Timestamp,
+			UserID:      rec.UserID,
+			Description: rec.Description,
+			Hash:        rec.VersionHash,
+		})
+		last = e
+	}
+	return result, nil
+}
+
+// compareFunc returns the ordering of index entries for a SortField. The
+// caller must hold rwlock.
+func (v *FileVersionManager) compareFunc(field SortField) func(a, b indexEntry) int {
+	switch field {
+	case SortByFile:
+		return func(a, b indexEntry) int {
+			return cmp.Or(strings.Compare(a.fileName, b.fileName), cmp.Compare(a.id, b.id))
+		}
+	case SortByUser:
+		return func(a, b indexEntry) int {
+			userA := v.records[a.fileName][a.id-1].UserID
+			userB := v.records[b.fileName][b.id-1].UserID
+			return cmp.Or(strings.Compare(userA, userB), compareByTime(a, b))
+		}
+	default:
+		return compareByTime
+	}
+}
+
+// pageToken is the cursor behind QueryResult.NextPageToken: the last
+// version returned and the order it was returned in.
+type pageToken struct {
+	Sort       SortField `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	FileName   string    `json:"f"`
+	ID         int       `json:"i"`
+}
+
+func encodePageToken(q Query, last indexEntry) string {
+	data, _ := json.Marshal(pageToken{Sort: q.Sort, Descending: q.Descending, FileName: last.fileName, ID: last.id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken returns the index entry a page token points at. The
+// caller must hold rwlock.
+func (v *FileVersionManager) decodePageToken(q Query) (indexEntry, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.PageToken)
+	if err != nil {
+		return indexEntry{}, ErrInvalidPageToken
+	}
+	var token pageToken
+	if err := json.Unmarshal(data, &token); err != nil || token.Sort != q.Sort || token.Descending != q.Descending {
+		return indexEntry{}, ErrInvalidPageToken
+	}
+	records := v.records[token.FileName]
+	if token.ID < 1 || token.ID > len(records) {
+		return indexEntry{}, ErrInvalidPageToken
+	}
+	return newIndexEntry(records[token.ID-1]), nil
+}
+
+// versionIndex holds the secondary indexes Query uses. Both keep entries
+// sorted by compareByTime. Records are never removed, only pruned, so
+// entries stay valid for the life of the manager.
+type versionIndex struct {
+	byTime []indexEntry
+	byUser map[string][]indexEntry
+}
+
+type indexEntry struct {
+	timestamp int64
+	fileName  string
+	id        int
+}
+
+func newIndexEntry(rec Record) indexEntry {
+	return indexEntry{timestamp: rec.Timestamp, fileName: rec.FileName, id: rec.ID}
+}
+
+func compareByTime(a, b indexEntry) int {
+	return cmp.Or(cmp.Compare(a.timestamp, b.timestamp), strings.Compare(a.fileName, b.fileName), cmp.Compare(a.id, b.id))
+}
+
+// rebuild indexes every record from scratch.
+func (ix *versionIndex) rebuild(records map[string][]Record) {
+	ix.byTime = nil
+	ix.byUser = make(map[string][]indexEntry)
+	for _, fileRecords := range records {
+		for _, rec := range fileRecords {
+			e := newIndexEntry(rec)
+			ix.byTime = append(ix.byTime, e)
+			ix.byUser[rec.UserID] = append(ix.byUser[rec.UserID], e)
+		}
+	}
+	slices.SortFunc(ix.byTime, compareByTime)
+	for _, entries := range ix.byUser {
+		slices.SortFunc(entries, compareByTime)
+	}
+}
+
+// add indexes a new record. New versions are normally the newest, making
+// this an append.
+func (ix *versionIndex) add(rec Record) {
+	e := newIndexEntry(rec)
+	ix.byTime = insertEntry(ix.byTime, e)
+	ix.byUser[rec.UserID] = insertEntry(ix.byUser[rec.UserID], e)
+}
+
+func insertEntry(entries []indexEntry, e indexEntry) []indexEntry {
+	if len(entries) == 0 || compareByTime(entries[len(entries)-1], e) < 0 {
+		return append(entries, e)
+	}
+	i, _ := slices.BinarySearchFunc(entries, e, compareByTime)
+	return slices.Insert(entries, i, e)
+}
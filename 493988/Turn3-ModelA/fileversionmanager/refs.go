@@ -0,0 +1,160 @@
+package fileversionmanager
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+)
+
+// DefaultBranch is the branch AddVersion appends to unless OnBranch is given.
+// It exists for every file and never needs to be created.
+const DefaultBranch = "main"
+
+// ListOption configures ListVersions.
+type ListOption func(*listConfig)
+
+type listConfig struct {
+	branch string
+}
+
+// InBranch limits ListVersions to the linear history of a branch: its head
+// and every ancestor, including versions from before it was forked.
+func InBranch(name string) ListOption {
+	return func(c *listConfig) {
+		c.branch = name
+	}
+}
+
+// Tag gives versionID the immutable name tag. Tags cannot be moved or
+// reused, and share a namespace with the file's branches.
+func (v *FileVersionManager) Tag(fileName, tag string, versionID int) error {
+	v.rwlock.Lock()
+	defer v.rwlock.Unlock()
+
+	if err := v.checkRefName(fileName, tag); err != nil {
+		return err
+	}
+	return v.addRef(Ref{FileName: fileName, Kind: RefTag, Name: tag, Target: versionID})
+}
+
+// CreateBranch forks a new branch named branch off fromID. Versions added
+// with OnBranch(branch) then build on fromID without affecting other
+// branches.
+func (v *FileVersionManager) CreateBranch(fileName, branch string, fromID int) error {
+	v.rwlock.Lock()
+	defer v.rwlock.Unlock()
+
+	if err := v.checkRefName(fileName, branch); err != nil {
+		return err
+	}
+	return v.addRef(Ref{FileName: fileName, Kind: RefBranch, Name: branch, Target: fromID})
+}
+
+// Tags returns the tags of a file and the versions they point at.
+func (v *FileVersionManager) Tags(fileName string) map[string]int {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+	return maps.Clone(v.tags[fileName])
+}
+
+// Branches returns the branches of a file and their head versions.
+func (v *FileVersionManager) Branches(fileName string) map[string]int {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+	return maps.Clone(v.heads[fileName])
+}
+
+// ResolveRef returns the version ID that ref names for a file. ref may be a
+// tag, a branch (resolving to its head) or a decimal version ID.
+func (v *FileVersionManager) ResolveRef(fileName, ref string) (int, error) {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+	return v.resolve(fileName, ref)
+}
+
+// GetVersionByRef retrieves the version ref names; see ResolveRef.
+func (v *FileVersionManager) GetVersionByRef(fileName, ref string) (Version, error) {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	versionID, err := v.resolve(fileName, ref)
+	if err != nil {
+		return nil, err
+	}
+	return v.load(v.records[fileName][versionID-1])
+}
+
+// resolve implements ResolveRef. The caller must hold rwlock.
+func (v *FileVersionManager) resolve(fileName, ref string) (int, error) {
+	if id, ok := v.tags[fileName][ref]; ok {
+		return id, nil
+	}
+	if id, ok := v.heads[fileName][ref]; ok && id > 0 {
+		return id, nil
+	}
+	if id, err := strconv.Atoi(ref); err == nil && id >= 1 && id <= len(v.records[fileName]) {
+		return id, nil
+	}
+	return 0, fmt.Errorf("%w for file: %s, ref: %s", ErrRefNotFound, fileName, ref)
+}
+
+// checkRefName rejects names that would be ambiguous to resolve. The caller
+// must hold rwlock.
+func (v *FileVersionManager) checkRefName(fileName, name string) error {
+	if name == "" {
+		return fmt.Errorf("empty ref name for file: %s", fileName)
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return fmt.Errorf("ref name for file: %s must not be a number: %s", fileName, name)
+	}
+	if _, ok := v.tags[fileName][name]; ok {
+		return fmt.Errorf("%w for file: %s, tag: %s", ErrTagExists, fileName, name)
+	}
+	if _, ok := v.heads[fileName][name]; ok || name == DefaultBranch {
+		return fmt.Errorf("%w for file: %s, branch: %s", ErrBranchExists, fileName, name)
+	}
+	return nil
+}
+
+// addRef persists ref and applies it. The caller must hold rwlock.
+func (v *FileVersionManager) addRef(ref Ref) error {
+	if ref.Target < 1 || ref.Target > len(v.records[ref.FileName]) {
+		return fmt.Errorf("%w for file: %s, id: %d", ErrVersionNotFound, ref.FileName, ref.Target)
+	}
+	if err := v.storage.AppendRef(ref); err != nil {
+		return fmt.Errorf("storing %s %s for file: %s: %w", ref.Kind, ref.Name, ref.FileName, err)
+	}
+
+	event := Event{Kind: EventTagged, FileName: ref.FileName, VersionID: ref.Target, Ref: ref.Name}
+	if ref.Kind == RefTag {
+		v.refMap(v.tags, ref.FileName)[ref.Name] = ref.Target
+	} else {
+		v.refMap(v.heads, ref.FileName)[ref.Name] = ref.Target
+		event.Kind = EventBranchCreated
+	}
+	v.publish(event)
+	return nil
+}
+
+// refMap returns the per-file map in refs, creating it if needed.
+func (v *FileVersionManager) refMap(refs map[string]map[string]int, fileName string) map[string]int {
+	m, ok := refs[fileName]
+	if !ok {
+		m = make(map[string]int)
+		refs[fileName] = m
+	}
+	return m
+}
+
+// history returns the records from the root of the file up to and
+// including head, following parent links. The caller must hold rwlock.
+func (v *FileVersionManager) history(records []Record, head int) []Record {
+	var chain []Record
+	for id := head; id != 0; id = records[id-1].Parent {
+		chain = append(chain, records[id-1])
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
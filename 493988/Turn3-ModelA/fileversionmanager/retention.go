@@ -0,0 +1,248 @@
+package fileversionmanager
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which versions of matching files survive
+// Compact. A version is kept if any rule keeps it; tagged versions and
+// branch heads are always kept. A policy with all rules zero keeps only
+// those.
+type RetentionPolicy struct {
+	// Pattern is a glob matched against file names. "*" does not cross "/"
+	// while "**" matches any number of path segments.
+	Pattern string
+	// KeepLast keeps the newest KeepLast versions.
+	KeepLast int
+	// KeepWithin keeps every version younger than KeepWithin.
+	KeepWithin time.Duration
+	// KeepDaily keeps the newest version of each of the KeepDaily most
+	// recent days (UTC) that have versions.
+	KeepDaily int
+	// KeepWeekly keeps the newest version of each of the KeepWeekly most
+	// recent ISO weeks that have versions.
+	KeepWeekly int
+}
+
+// WithRetentionPolicies sets the policies Compact enforces. For each file
+// the first policy whose Pattern matches applies; files matching no policy
+// keep their whole history.
+func WithRetentionPolicies(policies ...RetentionPolicy) Option {
+	return func(v *FileVersionManager) {
+		v.policies = policies
+	}
+}
+
+// CompactResult reports what Compact removed.
+type CompactResult struct {
+	// PrunedVersions is the number of versions whose content was dropped.
+	PrunedVersions int
+	// DeletedBlobs is the number of blobs removed from storage.
+	DeletedBlobs int
+}
+
+// Compact enforces the retention policies. The content of versions no
+// policy keeps is dropped, but their metadata stays in the index as a
+// pruned tombstone so version IDs, parent links and refs remain stable.
+// Pruned versions are skipped by ListVersions and GetVersion reports them
+// as pruned.
+//
+// Surviving versions that were stored as deltas against pruned ones are
+// re-encoded first, the index is then replaced atomically, and only then are
+// unreferenced blobs deleted, so an interrupted Compact never loses data.
+func (v *FileVersionManager) Compact() (CompactResult, error) {
+	v.rwlock.Lock()
+	defer v.rwlock.Unlock()
+
+	var result CompactResult
+	now := time.Now()
+	rebuilt := make(map[string][]Record)
+	prunedByFile := make(map[string]int)
+	for fileName, records := range v.records {
+		policy, ok := v.policyFor(fileName)
+		if !ok {
+			continue
+		}
+		keep := v.retained(fileName, records, policy, now)
+
+		pruned := 0
+		for _, rec := range records {
+			if !rec.Pruned && !keep[rec.ID] {
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			continue
+		}
+
+		newRecords, err := v.reencode(records, keep)
+		if err != nil {
+			return result, fmt.Errorf("compacting file: %s: %w", fileName, err)
+		}
+		rebuilt[fileName] = newRecords
+		prunedByFile[fileName] = pruned
+		result.PrunedVersions += pruned
+	}
+	if len(rebuilt) == 0 {
+		return result, nil
+	}
+
+	// Collect the blobs that the replaced records used and that nothing
+	// references afterwards.
+	candidates := make(map[string]bool)
+	for fileName := range rebuilt {
+		for _, rec := range v.records[fileName] {
+			if rec.Hash != "" {
+				candidates[rec.Hash] = true
+			}
+		}
+	}
+	var all []Record
+	for fileName, records := range v.records {
+		if newRecords, ok := rebuilt[fileName]; ok {
+			records = newRecords
+		}
+		for _, rec := range records {
+			delete(candidates, rec.Hash)
+		}
+		all = append(all, records...)
+	}
+
+	if err := v.storage.ReplaceRecords(all); err != nil {
+		return result, fmt.Errorf("rewriting version index: %w", err)
+	}
+	for fileName, records := range rebuilt {
+		v.records[fileName] = records
+		v.publish(Event{Kind: EventCompacted, FileName: fileName, PrunedVersions: prunedByFile[fileName]})
+	}
+
+	for hash := range candidates {
+		if err := v.storage.DeleteBlob(hash); err != nil {
+			return result, fmt.Errorf("deleting blob %s: %w", hash, err)
+		}
+		result.DeletedBlobs++
+	}
+	return result, nil
+}
+
+// policyFor returns the first policy matching fileName.
+func (v *FileVersionManager) policyFor(fileName string) (RetentionPolicy, bool) {
+	for _, policy := range v.policies {
+		if matchGlob(policy.Pattern, fileName) {
+			return policy, true
+		}
+	}
+	return RetentionPolicy{}, false
+}
+
+// retained returns the IDs of the versions of a file that policy keeps. The
+// caller must hold rwlock.
+func (v *FileVersionManager) retained(fileName string, records []Record, policy RetentionPolicy, now time.Time) map[int]bool {
+	keep := make(map[int]bool)
+	for _, id := range v.tags[fileName] {
+		keep[id] = true
+	}
+	for _, id := range v.heads[fileName] {
+		keep[id] = true
+	}
+
+	kept := 0
+	var lastDay, lastWeek string
+	days, weeks := 0, 0
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		if rec.Pruned {
+			continue
+		}
+		created := time.Unix(rec.Timestamp, 0).UTC()
+
+		if kept < policy.KeepLast {
+			keep[rec.ID] = true
+			kept++
+		}
+		if policy.KeepWithin > 0 && now.Sub(created) < policy.KeepWithin {
+			keep[rec.ID] = true
+		}
+		if day := created.Format(time.DateOnly); day != lastDay {
+			lastDay = day
+			if days < policy.KeepDaily {
+				keep[rec.ID] = true
+				days++
+			}
+		}
+		year, week := created.ISOWeek()
+		if key := fmt.Sprintf("%d-W%02d", year, week); key != lastWeek {
+			lastWeek = key
+			if weeks < policy.KeepWeekly {
+				keep[rec.ID] = true
+				weeks++
+			}
+		}
+	}
+	return keep
+}
+
+// reencode returns a copy of records in which versions not in keep are
+// pruned tombstones and every kept version is stored relative to kept
+// versions only. New blobs are written to storage; nothing is deleted. The
+// caller must hold rwlock.
+func (v *FileVersionManager) reencode(records []Record, keep map[int]bool) ([]Record, error) {
+	newRecords := make([]Record, len(records))
+	var prevData []byte
+	prevID, prevDepth := 0, 0
+	for i, rec := range records {
+		if rec.Pruned || !keep[rec.ID] {
+			rec.Pruned, rec.Hash, rec.Base = true, "", 0
+			newRecords[i] = rec
+			continue
+		}
+
+		data, err := v.content(records, rec)
+		if err != nil {
+			return nil, err
+		}
+		blob, base, depth := data, 0, 0
+		if prevID != 0 && prevDepth+1 < v.keyframeInterval {
+			if delta := encodeDelta(prevData, data); len(delta) < len(data) {
+				blob, base, depth = delta, prevID, prevDepth+1
+			}
+		}
+		if rec.Hash, err = v.storage.PutBlob(blob); err != nil {
+			return nil, err
+		}
+		rec.Base = base
+		newRecords[i] = rec
+		prevData, prevID, prevDepth = data, rec.ID, depth
+	}
+	return newRecords, nil
+}
+
+// matchGlob reports whether name matches pattern. Pattern segments are
+// matched with path.Match, and a "**" segment matches zero or more segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
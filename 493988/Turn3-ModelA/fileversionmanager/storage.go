@@ -0,0 +1,164 @@
+package fileversionmanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Record is the persisted metadata of a single version. Parent is the ID of
+// the version this one was derived from, or 0 for the first version, and
+// Branch the branch it was added on, empty for DefaultBranch. The
+// content itself is stored separately as a blob addressed by Hash. When Base
+// is non-zero the blob is a delta against the version with that ID rather
+// than the full content. Pruned records have had their content removed by
+// Compact and carry no Hash. ContentHash is the SHA-256 of the full content
+// and VersionHash links the record into the file's hash chain; both survive
+// pruning.
+type Record struct {
+	FileName    string `json:"file"`
+	ID          int    `json:"id"`
+	Parent      int    `json:"parent,omitempty"`
+	Hash        string `json:"hash"`
+	Base        int    `json:"base,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Pruned      bool   `json:"pruned,omitempty"`
+	ContentHash string `json:"content_hash"`
+	VersionHash string `json:"version_hash"`
+	Timestamp   int64  `json:"timestamp"`
+	UserID      string `json:"user_id"`
+	Description string `json:"description"`
+}
+
+// BranchName returns the branch the version was added on.
+func (r Record) BranchName() string {
+	if r.Branch == "" {
+		return DefaultBranch
+	}
+	return r.Branch
+}
+
+// Kinds of Ref.
+const (
+	RefTag    = "tag"
+	RefBranch = "branch"
+)
+
+// Ref is a persisted name for a version of a file. A tag ref points at its
+// version forever. A branch ref records the version the branch was forked
+// from; the branch head then follows the versions added on it.
+type Ref struct {
+	FileName string `json:"file"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Target   int    `json:"target"`
+}
+
+// Storage is the backend a FileVersionManager persists its history to.
+//
+// Blobs are content-addressed: PutBlob returns the hex SHA-256 of data and
+// storing identical content twice keeps a single copy. Records form an
+// append-only index of version metadata that LoadRecords replays in the
+// order they were appended; refs are kept the same way. ReplaceRecords
+// atomically swaps the whole index for a new one, after which DeleteBlob is
+// used to drop blobs nothing references any more.
+type Storage interface {
+	PutBlob(data []byte) (string, error)
+	GetBlob(hash string) ([]byte, error)
+	DeleteBlob(hash string) error
+	AppendRecord(rec Record) error
+	LoadRecords() ([]Record, error)
+	ReplaceRecords(records []Record) error
+	AppendRef(ref Ref) error
+	LoadRefs() ([]Ref, error)
+}
+
+// hashBlob returns the content address of data.
+func hashBlob(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryStorage is a Storage that keeps everything in process memory.
+type MemoryStorage struct {
+	blobs   map[string][]byte
+	records []Record
+	refs    []Ref
+	mu      sync.RWMutex
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{blobs: make(map[string][]byte)}
+}
+
+// PutBlob stores a copy of data under its SHA-256 hash.
+func (s *MemoryStorage) PutBlob(data []byte) (string, error) {
+	hash := hashBlob(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[hash]; !ok {
+		s.blobs[hash] = append([]byte{}, data...)
+	}
+	return hash, nil
+}
+
+// GetBlob returns a copy of the blob stored under hash.
+func (s *MemoryStorage) GetBlob(hash string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[hash]
+	if !ok {
+		return nil, fmt.Errorf("blob not found: %s", hash)
+	}
+	return append([]byte{}, data...), nil
+}
+
+// DeleteBlob removes the blob stored under hash, if any.
+func (s *MemoryStorage) DeleteBlob(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, hash)
+	return nil
+}
+
+// AppendRecord appends rec to the index.
+func (s *MemoryStorage) AppendRecord(rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+// LoadRecords returns all records in the order they were appended.
+func (s *MemoryStorage) LoadRecords() ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Record{}, s.records...), nil
+}
+
+// ReplaceRecords replaces the index with records.
+func (s *MemoryStorage) ReplaceRecords(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append([]Record{}, records...)
+	return nil
+}
+
+// AppendRef appends ref to the ref log.
+func (s *MemoryStorage) AppendRef(ref Ref) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs = append(s.refs, ref)
+	return nil
+}
+
+// LoadRefs returns all refs in the order they were appended.
+func (s *MemoryStorage) LoadRefs() ([]Ref, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Ref{}, s.refs...), nil
+}
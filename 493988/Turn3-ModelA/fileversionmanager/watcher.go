@@ -0,0 +1,332 @@
+package fileversionmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Defaults used by a Watcher when the corresponding option is not given.
+const (
+	DefaultPollInterval = 2 * time.Second
+	DefaultDebounce     = 500 * time.Millisecond
+	DefaultWatchUserID  = "watcher"
+)
+
+// WatchOption configures a Watcher.
+type WatchOption func(*watchConfig)
+
+type watchConfig struct {
+	include      []string
+	exclude      []string
+	pollInterval time.Duration
+	debounce     time.Duration
+	userID       string
+	onError      func(error)
+}
+
+// WithInclude limits the watcher to files whose path relative to the
+// watched root matches one of patterns. Patterns use the syntax of
+// RetentionPolicy.Pattern. Without WithInclude every file is watched.
+func WithInclude(patterns ...string) WatchOption {
+	return func(c *watchConfig) {
+		c.include = append(c.include, patterns...)
+	}
+}
+
+// WithExclude ignores files and directories whose relative path matches one
+// of patterns, even if they are included. An excluded directory is not
+// descended into.
+func WithExclude(patterns ...string) WatchOption {
+	return func(c *watchConfig) {
+		c.exclude = append(c.exclude, patterns...)
+	}
+}
+
+// WithPollInterval sets how often the tree is rescanned. Where inotify is
+// available changes also trigger an early rescan.
+func WithPollInterval(d time.Duration) WatchOption {
+	return func(c *watchConfig) {
+		c.pollInterval = max(d, time.Millisecond)
+	}
+}
+
+// WithDebounce sets how long a file must stay unchanged before it is
+// snapshotted, so that a burst of writes produces a single version.
+func WithDebounce(d time.Duration) WatchOption {
+	return func(c *watchConfig) {
+		c.debounce = max(d, 0)
+	}
+}
+
+// WithWatchUserID sets the user ID recorded on snapshots.
+func WithWatchUserID(userID string) WatchOption {
+	return func(c *watchConfig) {
+		c.userID = userID
+	}
+}
+
+// WithWatchErrorHandler sets a function that Run calls with errors that do
+// not stop it, such as a file that could not be read or stored. They are
+// dropped otherwise.
+func WithWatchErrorHandler(onError func(error)) WatchOption {
+	return func(c *watchConfig) {
+		c.onError = onError
+	}
+}
+
+// Watcher snapshots the files under a directory into a FileVersionManager.
+// Each file is versioned under its slash-separated path relative to the
+// root.
+//
+// A file is considered changed when its modification time or size differs
+// from the last scan. Once it has stayed unchanged for the debounce period
+// its content is hashed and a version is added only if the content differs
+// from the newest version on DefaultBranch, so touching a file or restarting
+// the watcher does not create duplicate versions. Deleted files are
+// forgotten; their history is kept.
+//
+// A Watcher is not safe for concurrent use.
+type Watcher struct {
+	manager  *FileVersionManager
+	root     string
+	config   watchConfig
+	files    map[string]*watchedFile
+	notifier *notifier
+}
+
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	// changedAt is when the file was last seen changing; zero once it has
+	// been snapshotted.
+	changedAt time.Time
+	// contentHash is the content hash of the newest version of the file.
+	contentHash string
+}
+
+// NewWatcher returns a Watcher that snapshots the tree under root into
+// manager. Nothing is watched until Run or Scan is called.
+func NewWatcher(manager *FileVersionManager, root string, opts ...WatchOption) *Watcher {
+	config := watchConfig{
+		pollInterval: DefaultPollInterval,
+		debounce:     DefaultDebounce,
+		userID:       DefaultWatchUserID,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &Watcher{
+		manager: manager,
+		root:    root,
+		config:  config,
+		files:   make(map[string]*watchedFile),
+	}
+}
+
+// Run scans the tree every poll interval, and on change notifications where
+// available, until ctx is done. It returns ctx.Err(), or an error if the
+// root cannot be scanned at all.
+func (w *Watcher) Run(ctx context.Context) error {
+	if _, err := os.Stat(w.root); err != nil {
+		return err
+	}
+	// inotify only makes changes show up sooner; without it polling alone
+	// finds them.
+	var wake <-chan struct{}
+	if n, err := newNotifier(); err == nil {
+		w.notifier = n
+		wake = n.wake
+		defer func() {
+			n.close()
+			w.notifier = nil
+		}()
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		case <-wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		now := time.Now()
+		if _, err := w.scan(now); err != nil {
+			if errors.Is(err, errRootUnreadable) {
+				return err
+			}
+			if w.config.onError != nil {
+				w.config.onError(err)
+			}
+		}
+		timer.Reset(w.nextScan(now))
+	}
+}
+
+// Scan walks the tree once and snapshots every file that has settled since
+// it changed. It returns the versions added and the errors met on the way.
+func (w *Watcher) Scan() ([]Version, error) {
+	return w.scan(time.Now())
+}
+
+var errRootUnreadable = errors.New("watch root unreadable")
+
+func (w *Watcher) scan(now time.Time) ([]Version, error) {
+	var added []Version
+	var errs []error
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(w.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == w.root {
+				return fmt.Errorf("%w: %w", errRootUnreadable, err)
+			}
+			errs = append(errs, err)
+			return nil
+		}
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && w.excluded(rel) {
+				return filepath.SkipDir
+			}
+			if w.notifier != nil {
+				w.notifier.watch(path)
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || w.excluded(rel) || !w.included(rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// Removed since it was listed.
+			return nil
+		}
+		seen[rel] = true
+		version, err := w.check(path, rel, info, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if version != nil {
+			added = append(added, version)
+		}
+		return nil
+	})
+	if err != nil {
+		return added, err
+	}
+
+	for rel := range w.files {
+		if !seen[rel] {
+			delete(w.files, rel)
+		}
+	}
+	return added, errors.Join(errs...)
+}
+
+// check updates the state of one file and snapshots it if it has settled.
+func (w *Watcher) check(path, rel string, info fs.FileInfo, now time.Time) (Version, error) {
+	file, ok := w.files[rel]
+	if !ok {
+		file = &watchedFile{contentHash: w.manager.headContentHash(rel)}
+		w.files[rel] = file
+	}
+	if !ok || !info.ModTime().Equal(file.modTime) || info.Size() != file.size {
+		file.modTime, file.size, file.changedAt = info.ModTime(), info.Size(), now
+	}
+	if file.changedAt.IsZero() || now.Sub(file.changedAt) < w.config.debounce {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("snapshotting %s: %w", rel, err)
+	}
+	contentHash := hashBlob(data)
+	if contentHash == file.contentHash {
+		file.changedAt = time.Time{}
+		return nil, nil
+	}
+
+	change := "modified"
+	if file.contentHash == "" {
+		change = "created"
+	}
+	description := fmt.Sprintf("Auto-snapshot: %s %s (%d bytes)", rel, change, len(data))
+	version, err := w.manager.AddVersion(rel, data, w.config.userID, description)
+	if err != nil {
+		// Left pending, so the next scan after the debounce retries.
+		file.changedAt = now
+		return nil, fmt.Errorf("snapshotting %s: %w", rel, err)
+	}
+	file.contentHash, file.changedAt = contentHash, time.Time{}
+	return version, nil
+}
+
+// nextScan returns how long to wait after a scan at now: the poll interval,
+// or less if a pending file settles sooner.
+func (w *Watcher) nextScan(now time.Time) time.Duration {
+	wait := w.config.pollInterval
+	for _, file := range w.files {
+		if !file.changedAt.IsZero() {
+			wait = min(wait, max(file.changedAt.Add(w.config.debounce).Sub(now), 0))
+		}
+	}
+	return wait
+}
+
+func (w *Watcher) included(rel string) bool {
+	if len(w.config.include) == 0 {
+		return true
+	}
+	for _, pattern := range w.config.include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) excluded(rel string) bool {
+	for _, pattern := range w.config.exclude {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// headContentHash returns the content hash of the head of DefaultBranch for
+// a file, or "" if it has no versions there.
+func (v *FileVersionManager) headContentHash(fileName string) string {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	head := v.heads[fileName][DefaultBranch]
+	if head == 0 {
+		return ""
+	}
+	return v.records[fileName][head-1].ContentHash
+}
@@ -11,13 +11,13 @@ func main() {
 	fvManager := fileversionmanager.GetInstance(versionFactory)
 
 	// Add some versions for a file
-	err := fvManager.AddVersion("testfile.txt", []byte("Version 1 data"), "user123", "Initial version")
+	_, err := fvManager.AddVersion("testfile.txt", []byte("Version 1 data"), "user123", "Initial version")
 	if err != nil {
 		fmt.Println("Error adding version 1:", err)
 		return
 	}
 
-	err = fvManager.AddVersion("testfile.txt", []byte("Version 2 data"), "user456", "Second version")
+	_, err = fvManager.AddVersion("testfile.txt", []byte("Version 2 data"), "user456", "Second version")
 	if err != nil {
 		fmt.Println("Error adding version 2:", err)
 		return
@@ -53,7 +53,7 @@ func main() {
 	}
 
 	// Simulate adding more versions and listing them
-	err = fvManager.AddVersion("testfile.txt", []byte("Version 3 data"), "user789", "Third version")
+	_, err = fvManager.AddVersion("testfile.txt", []byte("Version 3 data"), "user789", "Third version")
 	if err != nil {
 		fmt.Println("Error adding version 3:", err)
 		return
@@ -25,4 +25,23 @@ func main() {
 	}
 
 	fmt.Printf("Parsed User: %+v\n", user)
+
+	encoded, err := paramserializer.Encode(user)
+	if err != nil {
+		fmt.Printf("Error encoding user: %v\n", err)
+		return
+	}
+	fmt.Printf("Encoded query: %s\n", encoded)
+
+	// Clients sending comma-separated arrays and dotted objects.
+	decoder := paramserializer.Decoder{Format: paramserializer.Format{
+		Arrays:  paramserializer.ArrayComma,
+		Objects: paramserializer.ObjectDots,
+	}}
+	var other paramserializer.User
+	if err := decoder.Decode("user_id=7&name=Jane&age=41&tags=go,api&address.city=Boston&address.state=MA", &other); err != nil {
+		fmt.Printf("Error parsing query: %v\n", err)
+		return
+	}
+	fmt.Printf("Parsed User (comma/dots): %+v\n", other)
 }
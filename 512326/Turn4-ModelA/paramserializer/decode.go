@@ -0,0 +1,489 @@
+package paramserializer
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecodeError reports a query value that could not be decoded into the
+// field bound to its key.
+type DecodeError struct {
+	// Key is the query key, e.g. "address[coordinates][lat]".
+	Key string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decode parses rawQuery and stores the parameters in the struct dst
+// points to. A field is bound to the key named by its `query:"name"` tag,
+// or else to its name in snake_case, and a "-" tag skips it. The fields of
+// an embedded struct are bound as if they were fields of the outer struct.
+// Only fields whose keys appear in the query are set, so dst can carry
+// defaults.
+//
+// Nested keys use brackets: "address[coordinates][lat]" sets a field of a
+// field of a struct field, and "metadata[key]" sets an entry of a map.
+// Slices take repeated values, as in "tags=a&tags=b", "tags[]=a&tags[]=b"
+// or "tags[0]=a&tags[1]=b", the last also allowing struct elements as in
+// "items[0][name]=x". A slice is replaced, and a map is added to.
+//
+// Pointers are allocated as needed. Types implementing
+// encoding.TextUnmarshaler, including time.Time as RFC 3339, decode
+// themselves; a time.Time field can instead name a layout, as in
+// `query:"birthday,layout=2006-01-02"`. time.Duration uses
+// time.ParseDuration, and an interface{} field receives a string, a
+// []string for repeated values, or a map[string]any for nested keys.
+//
+// Keys without a field are ignored. The first value that does not fit its
+// field is returned as a *DecodeError.
+//
+// Decode reads bracket notation, or the formats fields name in their tags;
+// a Decoder can read other formats.
+func Decode(rawQuery string, dst any) error {
+	var d Decoder
+	return d.Decode(rawQuery, dst)
+}
+
+// Decoder decodes query strings of a Format, as Decode does bracket
+// notation.
+//
+// Whatever its format, a Decoder reads the values of a slice from
+// "tags[]=a", "tags=a" and "tags[0]=a" alike, and keys nested in brackets.
+// With ArrayComma it also splits values at commas, as in "tags=a,b", and
+// with ObjectDots it also reads keys nested after dots, as in
+// "address.city" or "items[0].name". As map keys can contain dots, keys
+// nested under a map entry still need brackets, as in "items[k].name".
+type Decoder struct {
+	Format
+}
+
+// Decode parses rawQuery and stores the parameters in the struct dst
+// points to, as the package's Decode does.
+func (d *Decoder) Decode(rawQuery string, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("paramserializer: Decode needs a non-nil pointer to a struct, got %T", dst)
+	}
+	root, err := parseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	return decodeStruct(rv.Elem(), root, "", d.Format)
+}
+
+// node holds the values of a query key and the keys nested under it.
+type node struct {
+	values   []string
+	children map[string]*node
+	// order lists the children in the order their keys first appeared.
+	order []string
+}
+
+func (n *node) child(name string) *node {
+	c, ok := n.children[name]
+	if !ok {
+		if n.children == nil {
+			n.children = make(map[string]*node)
+		}
+		c = &node{}
+		n.children[name] = c
+		n.order = append(n.order, name)
+	}
+	return c
+}
+
+// merge adds the values and children of src to n.
+func (n *node) merge(src *node) {
+	n.values = append(n.values, src.values...)
+	for _, name := range src.order {
+		n.child(name).merge(src.children[name])
+	}
+}
+
+// expandDots nests the children of n whose keys have dots, as in
+// "address.city" or "items[0].name", under the key before the first dot.
+// If only is not empty, only keys starting with the segment only are
+// expanded. Keys after the dot are expanded when their node is decoded.
+func (n *node) expandDots(only string) {
+	expanded := false
+	for _, name := range slices.Clone(n.order) {
+		head, tail, ok := cutDot(name)
+		if !ok {
+			continue
+		}
+		segments := splitKey(head)
+		if only != "" && segments[0] != only {
+			continue
+		}
+		c := n.children[name]
+		delete(n.children, name)
+		dst := n
+		for _, segment := range append(segments, splitKey(tail)...) {
+			dst = dst.child(segment)
+		}
+		dst.merge(c)
+		expanded = true
+	}
+	if expanded {
+		n.order = slices.DeleteFunc(n.order, func(name string) bool {
+			_, ok := n.children[name]
+			return !ok
+		})
+	}
+}
+
+// cutDot splits key at its first dot outside brackets.
+func cutDot(key string) (head, tail string, ok bool) {
+	depth := 0
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '[':
+			depth++
+		case ']':
+			depth = max(depth-1, 0)
+		case '.':
+			if depth == 0 {
+				return key[:i], key[i+1:], i > 0 && i < len(key)-1
+			}
+		}
+	}
+	return "", "", false
+}
+
+// parseQuery parses rawQuery into a tree of nested keys, keeping the order
+// of the parameters, which url.ParseQuery does not.
+func parseQuery(rawQuery string) (*node, error) {
+	root := &node{}
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(param, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return nil, err
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, err
+		}
+		n := root
+		for _, segment := range splitKey(key) {
+			n = n.child(segment)
+		}
+		n.values = append(n.values, value)
+	}
+	return root, nil
+}
+
+// splitKey splits a bracketed key such as "address[coordinates][lat]" into
+// its segments. "tags[]" gives "tags" and an empty segment. A key that is
+// not well formed is a single segment.
+func splitKey(key string) []string {
+	name, rest, ok := strings.Cut(key, "[")
+	if !ok || name == "" {
+		return []string{key}
+	}
+	segments := []string{name}
+	rest = "[" + rest
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return []string{key}
+		}
+		segments = append(segments, rest[1:end])
+		rest = rest[end+1:]
+	}
+	return segments
+}
+
+// subKey returns the query key of segment nested under key.
+func subKey(key, segment string) string {
+	if key == "" {
+		return segment
+	}
+	return key + "[" + segment + "]"
+}
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	timeType            = reflect.TypeFor[time.Time]()
+	durationType        = reflect.TypeFor[time.Duration]()
+)
+
+func decodeStruct(v reflect.Value, n *node, key string, format Format) error {
+	if format.Objects == ObjectDots {
+		n.expandDots("")
+	}
+	for _, f := range cachedFields(v.Type()) {
+		fieldFormat := format.with(f.opts)
+		if fieldFormat.Objects == ObjectDots && format.Objects != ObjectDots {
+			n.expandDots(f.name)
+		}
+		c, ok := n.children[f.name]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(fieldByIndex(v, f.index), c, format.subKey(key, f.name), f.opts, fieldFormat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeValue(v reflect.Value, n *node, key string, opts tagOptions, format Format) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(v.Elem(), n, key, opts, format)
+	}
+
+	if v.Type() == timeType && opts.layout != "" {
+		s, err := scalar(n, key)
+		if err != nil {
+			return err
+		}
+		t, err := time.Parse(opts.layout, s)
+		if err != nil {
+			return &DecodeError{Key: key, Err: err}
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		s, err := scalar(n, key)
+		if err != nil {
+			return err
+		}
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return &DecodeError{Key: key, Err: err}
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if len(n.values) > 0 {
+			return &DecodeError{Key: key, Err: errors.New("expected nested keys, got a value")}
+		}
+		return decodeStruct(v, n, key, format)
+	case reflect.Slice:
+		elems, err := elements(n, key, format)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i, e := range elems {
+			if err := decodeValue(slice.Index(i), e.node, e.key, opts, format); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		elems, err := elements(n, key, format)
+		if err != nil {
+			return err
+		}
+		if len(elems) > v.Len() {
+			return &DecodeError{Key: key, Err: fmt.Errorf("%d values for an array of %d", len(elems), v.Len())}
+		}
+		for i, e := range elems {
+			if err := decodeValue(v.Index(i), e.node, e.key, opts, format); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if len(n.values) > 0 {
+			return &DecodeError{Key: key, Err: errors.New("expected nested keys, got a value")}
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for _, name := range n.order {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := decodeValue(k, &node{values: []string{name}}, format.subKey(key, name), tagOptions{}, format); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(e, n.children[name], format.subKey(key, name), opts, format); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+		return nil
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return &DecodeError{Key: key, Err: fmt.Errorf("unsupported type %s", v.Type())}
+		}
+		a, err := anyValue(n, key)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(a))
+		return nil
+	}
+
+	s, err := scalar(n, key)
+	if err != nil {
+		return err
+	}
+	if err := setScalar(v, s); err != nil {
+		return &DecodeError{Key: key, Err: err}
+	}
+	return nil
+}
+
+// scalar returns the value of a key that takes a single value. Of repeated
+// values the first is used.
+func scalar(n *node, key string) (string, error) {
+	if len(n.children) > 0 {
+		return "", &DecodeError{Key: key, Err: fmt.Errorf("unexpected nested key %s", subKey(key, n.order[0]))}
+	}
+	if len(n.values) == 0 {
+		return "", &DecodeError{Key: key, Err: errors.New("missing value")}
+	}
+	return n.values[0], nil
+}
+
+// element is the node of an element of a slice, with the query key it came
+// from for errors.
+type element struct {
+	key  string
+	node *node
+}
+
+// elements returns the elements of a slice: the values of the key itself
+// and of key[], split at commas with ArrayComma, then the indexed keys in
+// order of their index.
+func elements(n *node, key string, format Format) ([]element, error) {
+	var elems []element
+	for _, value := range splitValues(n.values, format) {
+		elems = append(elems, element{key, &node{values: []string{value}}})
+	}
+	type indexed struct {
+		index int
+		element
+	}
+	var byIndex []indexed
+	for _, name := range n.order {
+		c := n.children[name]
+		if name == "" {
+			if len(c.children) > 0 {
+				return nil, &DecodeError{Key: subKey(key, ""), Err: errors.New("nested keys need an index, as in key[0][name]")}
+			}
+			for _, value := range splitValues(c.values, format) {
+				elems = append(elems, element{subKey(key, ""), &node{values: []string{value}}})
+			}
+			continue
+		}
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 {
+			return nil, &DecodeError{Key: subKey(key, name), Err: errors.New("expected an index")}
+		}
+		byIndex = append(byIndex, indexed{i, element{subKey(key, name), c}})
+	}
+	// Indexes only order the elements, so sparse indexes do not allocate.
+	slices.SortStableFunc(byIndex, func(a, b indexed) int { return a.index - b.index })
+	for _, e := range byIndex {
+		elems = append(elems, e.element)
+	}
+	return elems, nil
+}
+
+// splitValues splits values at commas with ArrayComma. An empty value has
+// no elements.
+func splitValues(values []string, format Format) []string {
+	if format.Arrays != ArrayComma {
+		return values
+	}
+	var split []string
+	for _, value := range values {
+		if value != "" {
+			split = append(split, strings.Split(value, ",")...)
+		}
+	}
+	return split
+}
+
+// anyValue returns the value of n for an interface{} field. A key cannot
+// have both values and nested keys, as in "a=1&a[b]=2".
+func anyValue(n *node, key string) (any, error) {
+	if len(n.children) > 0 {
+		if len(n.values) > 0 {
+			return nil, &DecodeError{Key: key, Err: fmt.Errorf("unexpected nested key %s after a value", subKey(key, n.order[0]))}
+		}
+		m := make(map[string]any, len(n.children))
+		for _, name := range n.order {
+			a, err := anyValue(n.children[name], subKey(key, name))
+			if err != nil {
+				return nil, err
+			}
+			m[name] = a
+		}
+		return m, nil
+	}
+	if len(n.values) == 1 {
+		return n.values[0], nil
+	}
+	return slices.Clone(n.values), nil
+}
+
+// setScalar parses s into v, which has a basic kind.
+func setScalar(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package paramserializer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type level int
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case 1:
+		return []byte("low"), nil
+	case 2:
+		return []byte("high"), nil
+	}
+	return nil, fmt.Errorf("unknown level %d", int(l))
+}
+
+type item struct {
+	Name string
+	Qty  int
+}
+
+type base struct {
+	ID      int       `query:"id"`
+	Created time.Time `query:"created,layout=2006-01-02"`
+}
+
+type inner struct {
+	Value string
+}
+
+type decodeTarget struct {
+	base
+	Name    string
+	Nested  struct{ Inner inner }
+	Tags    []string
+	Items   []item
+	Scores  map[string]int
+	Ptr     *int
+	Point   *Coordinates
+	At      time.Time
+	Timeout time.Duration
+	Level   level
+	Levels  []level
+	Any     any
+	Pair    [2]int
+	Skip    string `query:"-"`
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  decodeTarget
+	}{
+		{"empty", "", decodeTarget{}},
+		{"scalar and escapes", "name=Jane+Doe%26Co&ignored=1", decodeTarget{Name: "Jane Doe&Co"}},
+		{"first of repeated scalars", "name=a&name=b", decodeTarget{Name: "a"}},
+		{"nesting", "nested[inner][value]=deep", decodeTarget{Nested: struct{ Inner inner }{inner{"deep"}}}},
+		{"repeated slice", "tags=a&tags=b", decodeTarget{Tags: []string{"a", "b"}}},
+		{"bracket slice", "tags[]=a&tags[]=b", decodeTarget{Tags: []string{"a", "b"}}},
+		{"indexed slice in index order", "tags[1]=b&tags[0]=a", decodeTarget{Tags: []string{"a", "b"}}},
+		{"sparse indexes", "tags[10]=b&tags[3]=a", decodeTarget{Tags: []string{"a", "b"}}},
+		{"slice of structs", "items[0][name]=x&items[0][qty]=2&items[1][name]=y",
+			decodeTarget{Items: []item{{"x", 2}, {"y", 0}}}},
+		{"map", "scores[a]=1&scores[b]=2", decodeTarget{Scores: map[string]int{"a": 1, "b": 2}}},
+		{"pointers", "ptr=5&point[lat]=1.5", decodeTarget{Ptr: ptr(5), Point: &Coordinates{Lat: 1.5}}},
+		{"embedded struct", "id=7&created=2024-02-29", decodeTarget{base: base{ID: 7, Created: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)}}},
+		{"time and duration", "at=2024-01-02T03:04:05Z&timeout=1m30s",
+			decodeTarget{At: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Timeout: 90 * time.Second}},
+		{"text unmarshaler", "level=high&levels[]=low&levels[]=high", decodeTarget{Level: 2, Levels: []level{1, 2}}},
+		{"any value", "any=x", decodeTarget{Any: "x"}},
+		{"any values", "any=x&any=y", decodeTarget{Any: []string{"x", "y"}}},
+		{"any nested", "any[a]=1&any[b][c]=2", decodeTarget{Any: map[string]any{"a": "1", "b": map[string]any{"c": "2"}}}},
+		{"array", "pair=1&pair=2", decodeTarget{Pair: [2]int{1, 2}}},
+		{"skipped field", "skip=x", decodeTarget{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got decodeTarget
+			if err := Decode(tt.query, &got); err != nil {
+				t.Fatalf("Decode(%q): %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode(%q) = %+v, expected %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeKeepsDefaults(t *testing.T) {
+	got := decodeTarget{Name: "default", Tags: []string{"old"}, Scores: map[string]int{"a": 1}}
+	if err := Decode("tags=new&scores[b]=2", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := decodeTarget{Name: "default", Tags: []string{"new"}, Scores: map[string]int{"a": 1, "b": 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		key   string
+	}{
+		{"ptr=x", "ptr"},
+		{"pair=1&pair=2&pair=3", "pair"},
+		{"created=2024", "created"},
+		{"at=yesterday", "at"},
+		{"timeout=long", "timeout"},
+		{"level=medium", "level"},
+		{"scores[a]=x", "scores[a]"},
+		{"items[0][qty]=x", "items[0][qty]"},
+		{"levels[]=medium", "levels[]"},
+		{"levels=low&levels[4]=medium", "levels[4]"},
+		{"tags[x]=a", "tags[x]"},
+		{"items[][name]=x", "items[]"},
+		{"nested[inner][value][x]=1", "nested[inner][value]"},
+		// A value where nested keys are expected.
+		{"nested=1", "nested"},
+		{"nested[inner]=1", "nested[inner]"},
+		{"scores=1", "scores"},
+		{"items[0]=x", "items[0]"},
+		{"any=1&any[b]=2", "any"},
+		{"any[a]=1&any[a][b]=2", "any[a]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var got decodeTarget
+			err := Decode(tt.query, &got)
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("Expected a *DecodeError, got %v", err)
+			}
+			if de.Key != tt.key {
+				t.Errorf("Expected the error for key %q, got %q: %v", tt.key, de.Key, err)
+			}
+		})
+	}
+}
+
+func TestDecodeBadEscape(t *testing.T) {
+	for _, query := range []string{"name=%zz", "na%zzme=x"} {
+		var got decodeTarget
+		err := Decode(query, &got)
+		var de *DecodeError
+		if err == nil || errors.As(err, &de) {
+			t.Errorf("Decode(%q): expected an escape error, got %v", query, err)
+		}
+	}
+}
+
+func TestDecodeBadDestination(t *testing.T) {
+	var n int
+	for _, dst := range []any{decodeTarget{}, (*decodeTarget)(nil), &n, nil} {
+		if err := Decode("name=x", dst); err == nil {
+			t.Errorf("Decode into %T: expected an error", dst)
+		}
+	}
+}
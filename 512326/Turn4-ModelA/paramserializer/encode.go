@@ -0,0 +1,324 @@
+package paramserializer
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeError reports a field whose value cannot be written to a query.
+type EncodeError struct {
+	// Key is the query key of the field, e.g. "address[city]".
+	Key string
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("cannot encode %s: %v", e.Key, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+// Encode returns the query string of the struct v, or of the struct v
+// points to, in the form Decode reads back:
+//
+//	user_id=123&name=JohnDoe&tags[]=go&tags[]=backend&address[city]=NewYork&address[coordinates][lat]=40.7128&metadata[key1]=value1
+//
+// Keys are named as for Decode. Struct fields are written in the order they
+// are declared and map entries in the order of their keys, so the same
+// value always gives the same string. Slices of scalars are written as
+// repeated "key[]" parameters and slices of structs or maps with an index,
+// as in "items[0][name]". Nil pointers and interfaces are left out, as are
+// zero values of fields tagged omitempty. Names and values are escaped,
+// but brackets are written as they are. Map keys cannot contain brackets,
+// which Decode would read as nesting.
+//
+// Values are formatted the way Decode parses them: time.Time as RFC 3339
+// or its tag's layout, time.Duration as by Duration.String, and types
+// implementing encoding.TextMarshaler by MarshalText.
+//
+// Encode writes bracket notation, or the formats fields name in their tags;
+// an Encoder can write other formats.
+func Encode(v any) (string, error) {
+	var e Encoder
+	return e.Encode(v)
+}
+
+// Encoder encodes structs as query strings of a Format, as Encode does in
+// bracket notation. Slices of structs, maps or slices are indexed whatever
+// the format, as in "items[0][name]" or "items[0].name". With ObjectDots,
+// entries of maps of such values keep brackets, as in "items[k].name", for
+// a Decoder to read them back.
+type Encoder struct {
+	Format
+}
+
+// Encode returns the query string of the struct v, or of the struct v
+// points to, as the package's Encode does.
+func (enc *Encoder) Encode(v any) (string, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return "", fmt.Errorf("paramserializer: Encode needs a struct or a pointer to one, got %T", v)
+	}
+	if !rv.CanAddr() {
+		// Copy the struct so that MarshalText methods with pointer
+		// receivers are found, as they are through a pointer.
+		addressable := reflect.New(rv.Type()).Elem()
+		addressable.Set(rv)
+		rv = addressable
+	}
+	var e encodeState
+	if err := e.encodeStruct(rv, "", enc.Format); err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// encodeState accumulates the parameters of a query string.
+type encodeState struct {
+	strings.Builder
+}
+
+// add writes a parameter. key is already escaped.
+func (e *encodeState) add(key, value string) {
+	e.addRaw(key, url.QueryEscape(value))
+}
+
+// addRaw writes a parameter whose value is already escaped too.
+func (e *encodeState) addRaw(key, value string) {
+	if e.Len() > 0 {
+		e.WriteByte('&')
+	}
+	e.WriteString(key)
+	e.WriteByte('=')
+	e.WriteString(value)
+}
+
+func (e *encodeState) encodeStruct(v reflect.Value, key string, format Format) error {
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndexNoAlloc(v, f.index)
+		if !ok || (f.opts.omitEmpty && fv.IsZero()) {
+			continue
+		}
+		if err := e.encodeValue(fv, format.subKey(key, url.QueryEscape(f.name)), f.opts, format.with(f.opts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encodeState) encodeValue(v reflect.Value, key string, opts tagOptions, format Format) error {
+	v, ok := indirect(v)
+	if !ok {
+		return nil
+	}
+
+	if s, ok, err := formatText(v, opts); ok {
+		if err != nil {
+			return &EncodeError{Key: key, Err: err}
+		}
+		e.add(key, s)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return e.encodeStruct(v, key, format)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		scalar := isScalar(v.Type().Elem())
+		if scalar && format.Arrays == ArrayComma {
+			return e.encodeComma(v, key, opts)
+		}
+		for i := 0; i < v.Len(); i++ {
+			var elemKey string
+			switch {
+			case !scalar || format.Arrays == ArrayIndex:
+				elemKey = subKey(key, strconv.Itoa(i))
+			case format.Arrays == ArrayRepeat:
+				elemKey = key
+			default:
+				elemKey = key + "[]"
+			}
+			if err := e.encodeValue(v.Index(i), elemKey, opts, format); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		entries, err := sortedEntries(v)
+		if err != nil {
+			return &EncodeError{Key: key, Err: err}
+		}
+		entryKey := format.subKey
+		if !isScalar(v.Type().Elem()) {
+			// A Decoder reads dotted keys under a map entry as part of
+			// the entry's key.
+			entryKey = subKey
+		}
+		for _, en := range entries {
+			if strings.ContainsAny(en.name, "[]") {
+				// Decode unescapes keys before it splits them.
+				return &EncodeError{Key: key, Err: fmt.Errorf("map key %q contains a bracket", en.name)}
+			}
+			if err := e.encodeValue(en.value, entryKey(key, url.QueryEscape(en.name)), opts, format); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	s, err := formatScalar(v)
+	if err != nil {
+		return &EncodeError{Key: key, Err: err}
+	}
+	e.add(key, s)
+	return nil
+}
+
+// encodeComma writes the elements of the slice or array v, which are
+// written as single values, joined with commas.
+func (e *encodeState) encodeComma(v reflect.Value, key string, opts tagOptions) error {
+	var parts []string
+	for i := 0; i < v.Len(); i++ {
+		elem, ok := indirect(v.Index(i))
+		if !ok {
+			continue
+		}
+		s, ok, err := formatText(elem, opts)
+		if !ok {
+			s, err = formatScalar(elem)
+		}
+		if err == nil && strings.Contains(s, ",") {
+			err = fmt.Errorf("value %q contains a comma", s)
+		}
+		if err != nil {
+			return &EncodeError{Key: subKey(key, strconv.Itoa(i)), Err: err}
+		}
+		parts = append(parts, url.QueryEscape(s))
+	}
+	if len(parts) > 0 {
+		e.addRaw(key, strings.Join(parts, ","))
+	}
+	return nil
+}
+
+// indirect follows the pointers and interfaces of v. It reports false if
+// one of them is nil.
+func indirect(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// mapEntry is an entry of a map with its key formatted as a query key
+// segment.
+type mapEntry struct {
+	name  string
+	value reflect.Value
+}
+
+// sortedEntries returns the entries of map v in the order of their keys.
+func sortedEntries(v reflect.Value) ([]mapEntry, error) {
+	entries := make([]mapEntry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		name, ok, err := formatText(iter.Key(), tagOptions{})
+		if !ok {
+			name, err = formatScalar(iter.Key())
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, mapEntry{name, iter.Value()})
+	}
+	slices.SortFunc(entries, func(a, b mapEntry) int { return strings.Compare(a.name, b.name) })
+	return entries, nil
+}
+
+// formatText formats the types that format themselves: time.Time with a
+// layout, and encoding.TextMarshaler. It reports whether v is one of them.
+func formatText(v reflect.Value, opts tagOptions) (string, bool, error) {
+	if v.Type() == timeType && opts.layout != "" {
+		return v.Interface().(time.Time).Format(opts.layout), true, nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), true, err
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textMarshalerType) {
+		text, err := v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), true, err
+	}
+	return "", false, nil
+}
+
+// formatScalar formats v, which has a basic kind, the way setScalar parses
+// it.
+func formatScalar(v reflect.Value) (string, error) {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// isScalar reports whether values of t are written as a single value, so
+// that a slice of them can use "key[]".
+func isScalar(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType || t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+		return false
+	}
+	return true
+}
+
+// fieldByIndexNoAlloc is fieldByIndex for reading: it reports false if a
+// nil embedded struct pointer is on the way.
+func fieldByIndexNoAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,75 @@
+package paramserializer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	user := User{
+		ID:       123,
+		Name:     "John Doe",
+		Age:      30,
+		Tags:     []string{"go", "backend"},
+		Address:  Address{City: "New York", State: "NY", Coordinates: Coordinates{Lat: 40.7128, Lng: -74.006}},
+		Metadata: map[string]string{"key2": "value2", "key1": "value1"},
+	}
+	want := "user_id=123&name=John+Doe&age=30&tags[]=go&tags[]=backend" +
+		"&address[city]=New+York&address[state]=NY" +
+		"&address[coordinates][lat]=40.7128&address[coordinates][lng]=-74.006" +
+		"&metadata[key1]=value1&metadata[key2]=value2"
+	for i := 0; i < 10; i++ {
+		got, err := Encode(&user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	in := User{
+		ID:   1,
+		Name: "O'Brien & Sons = 100%+ café",
+		Age:  42,
+		Tags: []string{"a&b", "c=d", "e f", "g+h", "50%", "[x]", "y.z", ""},
+		Address: Address{
+			City:  "Saint-Étienne?",
+			State: "#1",
+		},
+		Metadata: map[string]string{
+			"a&b":        "1",
+			"c=d":        "2",
+			"e f":        "3",
+			"50%":        "4",
+			"dotted.key": "5",
+			".":          "6",
+			"":           "7",
+			"ключ":       "8",
+		},
+	}
+	query, err := Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out User
+	if err := Decode(query, &out); err != nil {
+		t.Fatalf("Decode(%q): %v", query, err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected %+v, got %+v from %s", in, out, query)
+	}
+}
+
+func TestEncodeBracketInMapKey(t *testing.T) {
+	for _, key := range []string{"k[1]", "k]", "[k"} {
+		_, err := Encode(User{Metadata: map[string]string{key: "v"}})
+		var ee *EncodeError
+		if !errors.As(err, &ee) || ee.Key != "metadata" {
+			t.Errorf("Map key %q: expected an *EncodeError for metadata, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package paramserializer
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// field is a struct field bound to a query key.
+type field struct {
+	// name is the key of the field within its struct, e.g. "city" in
+	// "address[city]".
+	name  string
+	index []int
+	typ   reflect.Type
+	opts  tagOptions
+	// rules are the rules of the field's validate tag.
+	rules []ruleCall
+}
+
+// tagOptions are the options following the name in a query tag.
+type tagOptions struct {
+	omitEmpty bool
+	// layout is the time.Parse layout of a time.Time field.
+	layout string
+	// arrays and objects override the Format of the field's value if set.
+	arrays  *ArrayFormat
+	objects *ObjectFormat
+}
+
+// parseTag splits a query tag such as `query:"created,layout=2006-01-02"`
+// or `query:"tags,arrays=comma"` into the key name and its options.
+func parseTag(tag string) (string, tagOptions) {
+	name, rest, _ := strings.Cut(tag, ",")
+	var opts tagOptions
+	for rest != "" {
+		var opt string
+		opt, rest, _ = strings.Cut(rest, ",")
+		switch key, value, _ := strings.Cut(opt, "="); key {
+		case "omitempty":
+			opts.omitEmpty = true
+		case "layout":
+			opts.layout = value
+		case "arrays":
+			if f, ok := arrayFormats[value]; ok {
+				opts.arrays = &f
+			}
+		case "objects":
+			if f, ok := objectFormats[value]; ok {
+				opts.objects = &f
+			}
+		}
+	}
+	return name, opts
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns the fields of struct type t bound to query keys.
+//
+// A field is bound to the name in its query tag, or else to its name in
+// snake_case, and is skipped if the tag is "-". The fields of an embedded
+// struct without a tag are bound as if they were fields of t, unless t has
+// a field bound to the same name itself.
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return fields.([]field)
+}
+
+func typeFields(t reflect.Type) []field {
+	var all []field
+	direct := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("query")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && !tagged {
+			embedded := sf.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				// Fields of an unexported embedded struct are still
+				// settable, but not through a nil pointer to one.
+				if !sf.IsExported() && sf.Type.Kind() == reflect.Pointer {
+					continue
+				}
+				for _, f := range cachedFields(embedded) {
+					f.index = append([]int{i}, f.index...)
+					all = append(all, f)
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts := parseTag(tag)
+		if name == "" {
+			name = snakeCase(sf.Name)
+		}
+		direct[name] = true
+		all = append(all, field{name: name, index: []int{i}, typ: sf.Type, opts: opts, rules: parseRules(sf.Tag.Get("validate"))})
+	}
+
+	// Keep fields in declaration order, dropping promoted fields hidden by
+	// a field of t or by an earlier promoted field.
+	fields := make([]field, 0, len(all))
+	seen := make(map[string]bool)
+	for _, f := range all {
+		promoted := len(f.index) > 1
+		if seen[f.name] || (promoted && direct[f.name]) {
+			continue
+		}
+		seen[f.name] = true
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// snakeCase converts a Go field name to snake_case, keeping initialisms
+// together: "UserID" becomes "user_id" and "HTTPHeader" "http_header".
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			lowerBefore := i > 0 && !unicode.IsUpper(runes[i-1])
+			lowerAfter := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if lowerBefore || lowerAfter {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fieldByIndex returns the field of struct v at index, allocating nil
+// embedded struct pointers on the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
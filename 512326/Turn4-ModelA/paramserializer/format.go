@@ -0,0 +1,71 @@
+package paramserializer
+
+// ArrayFormat is how the values of a slice or array are written in a query.
+type ArrayFormat int
+
+const (
+	// ArrayBrackets repeats the key with empty brackets, as in
+	// "tags[]=a&tags[]=b".
+	ArrayBrackets ArrayFormat = iota
+	// ArrayRepeat repeats the key, as in "tags=a&tags=b".
+	ArrayRepeat
+	// ArrayComma joins the values with commas, as in "tags=a,b". The
+	// values cannot contain commas themselves.
+	ArrayComma
+	// ArrayIndex indexes the key, as in "tags[0]=a&tags[1]=b".
+	ArrayIndex
+)
+
+var arrayFormats = map[string]ArrayFormat{
+	"brackets": ArrayBrackets,
+	"repeat":   ArrayRepeat,
+	"comma":    ArrayComma,
+	"index":    ArrayIndex,
+}
+
+// ObjectFormat is how the keys of a struct or map are nested in a query.
+type ObjectFormat int
+
+const (
+	// ObjectBrackets nests keys in brackets, as in "address[city]", the
+	// deepObject style of OpenAPI.
+	ObjectBrackets ObjectFormat = iota
+	// ObjectDots nests keys after dots, as in "address.city".
+	ObjectDots
+)
+
+var objectFormats = map[string]ObjectFormat{
+	"brackets": ObjectBrackets,
+	"dots":     ObjectDots,
+}
+
+// Format is a dialect of query strings. The zero Format is bracket
+// notation, as in "tags[]=a&address[city]=b".
+//
+// A field can use another format for its value, including the values
+// nested in it, with the arrays and objects options of its query tag, as in
+// `query:"tags,arrays=comma"` or `query:"address,objects=dots"`.
+type Format struct {
+	Arrays  ArrayFormat
+	Objects ObjectFormat
+}
+
+// with returns the format of a field with opts.
+func (f Format) with(opts tagOptions) Format {
+	if opts.arrays != nil {
+		f.Arrays = *opts.arrays
+	}
+	if opts.objects != nil {
+		f.Objects = *opts.objects
+	}
+	return f
+}
+
+// subKey returns the query key of the field or map entry name nested under
+// key. Elements of slices are always indexed with brackets, by subKey.
+func (f Format) subKey(key, name string) string {
+	if f.Objects == ObjectDots && key != "" {
+		return key + "." + name
+	}
+	return subKey(key, name)
+}
@@ -0,0 +1,213 @@
+package paramserializer
+
+import (
+	"reflect"
+	"testing"
+)
+
+type place struct {
+	City string
+	Geo  Coordinates
+}
+
+type formatTarget struct {
+	Tags   []string
+	Items  []item
+	Place  place
+	Scores map[string]int
+}
+
+var formats = []struct {
+	name   string
+	format Format
+}{
+	{"brackets", Format{}},
+	{"repeat", Format{Arrays: ArrayRepeat}},
+	{"comma", Format{Arrays: ArrayComma}},
+	{"index", Format{Arrays: ArrayIndex}},
+	{"brackets/dots", Format{Objects: ObjectDots}},
+	{"repeat/dots", Format{Arrays: ArrayRepeat, Objects: ObjectDots}},
+	{"comma/dots", Format{Arrays: ArrayComma, Objects: ObjectDots}},
+	{"index/dots", Format{Arrays: ArrayIndex, Objects: ObjectDots}},
+}
+
+func TestDecoderArrayForms(t *testing.T) {
+	queries := []struct {
+		form  string
+		query string
+	}{
+		{"brackets", "tags[]=a&tags[]=b"},
+		{"repeat", "tags=a&tags=b"},
+		{"index", "tags[1]=b&tags[0]=a"},
+		{"mixed", "tags=a&tags[]=b"},
+	}
+	want := formatTarget{Tags: []string{"a", "b"}}
+	for _, f := range formats {
+		d := Decoder{f.format}
+		for _, q := range queries {
+			t.Run(f.name+"/"+q.form, func(t *testing.T) {
+				var got formatTarget
+				if err := d.Decode(q.query, &got); err != nil {
+					t.Errorf("Decode(%q): %v", q.query, err)
+					return
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Decode(%q): expected %+v, got %+v", q.query, want, got)
+				}
+			})
+		}
+
+		// Commas separate values only with ArrayComma.
+		t.Run(f.name+"/comma", func(t *testing.T) {
+			const query = "tags=a,b&tags[]=c,d"
+			var got formatTarget
+			if err := d.Decode(query, &got); err != nil {
+				t.Errorf("Decode(%q): %v", query, err)
+				return
+			}
+			want := []string{"a,b", "c,d"}
+			if f.format.Arrays == ArrayComma {
+				want = []string{"a", "b", "c", "d"}
+			}
+			if !reflect.DeepEqual(got.Tags, want) {
+				t.Errorf("Decode(%q): expected %q, got %q", query, want, got.Tags)
+			}
+		})
+	}
+}
+
+func TestDecoderObjectForms(t *testing.T) {
+	tests := []struct {
+		query    string
+		brackets formatTarget
+		dots     formatTarget
+	}{
+		{
+			"place[city]=x&place[geo][lat]=1",
+			formatTarget{Place: place{City: "x", Geo: Coordinates{Lat: 1}}},
+			formatTarget{Place: place{City: "x", Geo: Coordinates{Lat: 1}}},
+		},
+		{
+			"place.city=x&place.geo.lat=1",
+			formatTarget{},
+			formatTarget{Place: place{City: "x", Geo: Coordinates{Lat: 1}}},
+		},
+		{
+			"place.city=x&place[geo].lat=1&place.geo[lng]=2",
+			formatTarget{},
+			formatTarget{Place: place{City: "x", Geo: Coordinates{Lat: 1, Lng: 2}}},
+		},
+		{
+			"items[0].name=x&items[1].qty=2&items[0][qty]=1",
+			formatTarget{Items: []item{{"", 1}}},
+			formatTarget{Items: []item{{"x", 1}, {"", 2}}},
+		},
+		{
+			// Map keys keep their dots.
+			"scores.a.b=1&scores[c.d]=2",
+			formatTarget{Scores: map[string]int{"c.d": 2}},
+			formatTarget{Scores: map[string]int{"a.b": 1, "c.d": 2}},
+		},
+	}
+	for _, f := range formats {
+		d := Decoder{f.format}
+		for _, tt := range tests {
+			t.Run(f.name+"/"+tt.query, func(t *testing.T) {
+				want := tt.brackets
+				if f.format.Objects == ObjectDots {
+					want = tt.dots
+				}
+				var got formatTarget
+				if err := d.Decode(tt.query, &got); err != nil {
+					t.Errorf("Decode(%q): %v", tt.query, err)
+					return
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Decode(%q): expected %+v, got %+v", tt.query, want, got)
+				}
+			})
+		}
+	}
+}
+
+type overrides struct {
+	Tags  []string `query:"tags,arrays=comma"`
+	IDs   []int    `query:"ids,arrays=index"`
+	Place place    `query:"place,objects=dots"`
+	Other place    `query:"other,objects=brackets"`
+}
+
+func TestFieldFormatOverrides(t *testing.T) {
+	in := overrides{
+		Tags:  []string{"a", "b"},
+		IDs:   []int{1, 2},
+		Place: place{City: "x", Geo: Coordinates{Lat: 1}},
+		Other: place{City: "y"},
+	}
+	want := "tags=a,b&ids[0]=1&ids[1]=2&place.city=x&place.geo.lat=1&place.geo.lng=0" +
+		"&other[city]=y&other[geo][lat]=0&other[geo][lng]=0"
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			e := Encoder{f.format}
+			if got, err := e.Encode(in); err != nil {
+				t.Errorf("Encode: %v", err)
+			} else if got != want {
+				t.Errorf("Encode: expected %s, got %s", want, got)
+			}
+
+			d := Decoder{f.format}
+			var out overrides
+			if err := d.Decode(want, &out); err != nil {
+				t.Errorf("Decode: %v", err)
+			} else if !reflect.DeepEqual(out, in) {
+				t.Errorf("Decode: expected %+v, got %+v", in, out)
+			}
+		})
+	}
+}
+
+func TestEncoderFormats(t *testing.T) {
+	in := formatTarget{
+		Tags:   []string{"a", "b"},
+		Items:  []item{{"x", 1}},
+		Place:  place{City: "c"},
+		Scores: map[string]int{"k": 1},
+	}
+	arrays := map[ArrayFormat]string{
+		ArrayBrackets: "tags[]=a&tags[]=b",
+		ArrayRepeat:   "tags=a&tags=b",
+		ArrayComma:    "tags=a,b",
+		ArrayIndex:    "tags[0]=a&tags[1]=b",
+	}
+	objects := map[ObjectFormat]string{
+		ObjectBrackets: "&items[0][name]=x&items[0][qty]=1&place[city]=c&place[geo][lat]=0&place[geo][lng]=0&scores[k]=1",
+		ObjectDots:     "&items[0].name=x&items[0].qty=1&place.city=c&place.geo.lat=0&place.geo.lng=0&scores.k=1",
+	}
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			e := Encoder{f.format}
+			got, err := e.Encode(in)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if want := arrays[f.format.Arrays] + objects[f.format.Objects]; got != want {
+				t.Errorf("Encode: expected %s, got %s", want, got)
+			}
+
+			d := Decoder{f.format}
+			var out formatTarget
+			if err := d.Decode(got, &out); err != nil {
+				t.Errorf("Decode(%q): %v", got, err)
+			} else if !reflect.DeepEqual(out, in) {
+				t.Errorf("Round trip: expected %+v, got %+v", in, out)
+			}
+		})
+	}
+}
+
+func TestEncoderCommaErrors(t *testing.T) {
+	e := Encoder{Format{Arrays: ArrayComma}}
+	if _, err := e.Encode(formatTarget{Tags: []string{"a", "b,c"}}); err == nil {
+		t.Error("Expected an error for a value with a comma")
+	}
+}
@@ -0,0 +1,509 @@
+package paramserializer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError reports a value that breaks a validation rule.
+type FieldError struct {
+	// Key is the query key of the value, e.g. "address[city]" or
+	// "tags[2]", and empty for a rule over the whole of the value passed to
+	// Validate.
+	Key string
+	// Rule is the name of the rule, e.g. "required", and empty for an
+	// error returned by a StructValidator.
+	Rule string
+	// Param is the parameter of the rule, e.g. "1" in "min=1".
+	Param string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Key == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors is the list of values that break their rules, as
+// returned by Validate.
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FieldValue is what a Rule checks.
+type FieldValue struct {
+	// Key is the query key of the value, e.g. "address[city]".
+	Key string
+	// Value is the value, with pointers and interfaces followed.
+	Value reflect.Value
+	// Param is the parameter of the rule, e.g. "1" in "min=1".
+	Param string
+	// Struct is the struct holding the field, for rules comparing fields.
+	Struct reflect.Value
+}
+
+// Rule checks a value and returns an error saying how it breaks the rule,
+// such as "must be at least 1", or nil if it does not.
+type Rule func(fv FieldValue) error
+
+// StructValidator is implemented by structs with rules spanning several
+// fields, which Validate checks after the rules of the fields. Validate
+// should not call the package's Validate on the struct itself, as its
+// fields are validated already.
+//
+// An error of type ValidationErrors reports the values at its keys,
+// relative to the struct, e.g. "end" for the field at "period[end]". Any
+// other error is reported at the key of the struct.
+type StructValidator interface {
+	Validate() error
+}
+
+// ruleCall is a rule named in a validate tag, with its parameter.
+type ruleCall struct {
+	name, param string
+}
+
+// parseRules splits a validate tag such as `validate:"required,max=120"`
+// into its rules.
+func parseRules(tag string) []ruleCall {
+	var calls []ruleCall
+	for _, s := range strings.Split(tag, ",") {
+		if s == "" {
+			continue
+		}
+		name, param, _ := strings.Cut(s, "=")
+		calls = append(calls, ruleCall{name, param})
+	}
+	return calls
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"required":  required,
+		"min":       boundRule("at least ", func(got, limit float64) bool { return got >= limit }),
+		"max":       boundRule("at most ", func(got, limit float64) bool { return got <= limit }),
+		"len":       boundRule("", func(got, limit float64) bool { return got == limit }),
+		"email":     email,
+		"oneof":     oneOf,
+		"eqfield":   fieldRule("equal", false, func(c int) bool { return c == 0 }),
+		"nefield":   fieldRule("differ from", false, func(c int) bool { return c != 0 }),
+		"gtfield":   fieldRule("be greater than", true, func(c int) bool { return c > 0 }),
+		"gtefield":  fieldRule("be at least", true, func(c int) bool { return c >= 0 }),
+		"ltfield":   fieldRule("be less than", true, func(c int) bool { return c < 0 }),
+		"ltefield":  fieldRule("be at most", true, func(c int) bool { return c <= 0 }),
+		"omitempty": nil, // handled by validateField
+		"dive":      nil, // handled by validateField
+	}
+)
+
+// RegisterRule makes a rule available to validate tags under name. It
+// panics if name is empty, contains a ',' or '=', or is already taken,
+// including by a built-in rule.
+func RegisterRule(name string, rule Rule) {
+	if name == "" || strings.ContainsAny(name, ",=") {
+		panic(fmt.Sprintf("paramserializer: invalid rule name %q", name))
+	}
+	if rule == nil {
+		panic("paramserializer: RegisterRule rule is nil")
+	}
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	if _, dup := rules[name]; dup {
+		panic(fmt.Sprintf("paramserializer: RegisterRule called twice for rule %q", name))
+	}
+	rules[name] = rule
+}
+
+func lookupRule(name string) (Rule, bool) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	rule, ok := rules[name]
+	return rule, ok && rule != nil
+}
+
+// Validate checks the struct v, or the struct v points to, against the
+// rules of the `validate` tags of its fields, as in
+// `validate:"required,min=1,max=120"`. Only fields bound to a query key are
+// checked, and errors are reported at those keys.
+//
+// The built-in rules are:
+//
+//   - required: the value is not zero, and a string is not blank, a slice
+//     or map not empty, and a pointer not nil.
+//   - min=n, max=n, len=n: a number is at least, at most or exactly n, and
+//     a string, slice or map has that length. The n of a time.Duration is
+//     a duration, as in "max=1h".
+//   - email: a string is an email address.
+//   - oneof=a b c: the value is one of the values separated by spaces.
+//   - eqfield=F, nefield=F, gtfield=F, gtefield=F, ltfield=F, ltefield=F:
+//     the value is equal to, different from, greater than, at least, less
+//     than or at most the field named F of the same struct. The field is
+//     named by its Go name.
+//
+// omitempty skips the rules of a zero value. dive applies the rules after
+// it to each element of a slice, array or map, reported at keys such as
+// "tags[2]", and the rules before it to the value itself. More rules can
+// be added with RegisterRule. A nil pointer breaks only required.
+//
+// Structs are validated field by field, including those nested in fields,
+// slices and maps, and then by their Validate method if they implement
+// StructValidator.
+//
+// All values that break a rule are reported, one error for each, in a
+// ValidationErrors. Other errors, such as a tag naming an unknown rule,
+// are returned on their own.
+func Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("paramserializer: Validate needs a struct or a pointer to one, got %T", v)
+	}
+	var vd validator
+	if err := vd.validateStruct(rv, ""); err != nil {
+		return err
+	}
+	if len(vd.errs) > 0 {
+		return vd.errs
+	}
+	return nil
+}
+
+// validator collects the errors of a call of Validate.
+type validator struct {
+	errs ValidationErrors
+}
+
+func (vd *validator) validateStruct(v reflect.Value, key string) error {
+	if !v.CanAddr() {
+		// Copy the struct so that Validate methods with pointer
+		// receivers are found, as they are through a pointer.
+		addressable := reflect.New(v.Type()).Elem()
+		addressable.Set(v)
+		v = addressable
+	}
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndexNoAlloc(v, f.index)
+		if !ok {
+			continue
+		}
+		if err := vd.validateField(fv, subKey(key, f.name), f.rules, v); err != nil {
+			return err
+		}
+	}
+
+	sv, ok := v.Addr().Interface().(StructValidator)
+	if !ok {
+		return nil
+	}
+	err := sv.Validate()
+	var errs ValidationErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &errs):
+		for _, e := range errs {
+			e := *e
+			e.Key = joinKey(key, e.Key)
+			vd.errs = append(vd.errs, &e)
+		}
+	default:
+		vd.errs = append(vd.errs, &FieldError{Key: key, Err: err})
+	}
+	return nil
+}
+
+// validateField checks v, at key in struct parent, against calls, and then
+// validates the structs within it.
+func (vd *validator) validateField(v reflect.Value, key string, calls []ruleCall, parent reflect.Value) error {
+	own, elems := calls, []ruleCall(nil)
+	dive := slices.IndexFunc(calls, func(c ruleCall) bool { return c.name == "dive" })
+	if dive >= 0 {
+		own, elems = calls[:dive], calls[dive+1:]
+	}
+	if v.IsZero() && slices.ContainsFunc(own, func(c ruleCall) bool { return c.name == "omitempty" }) {
+		return nil
+	}
+
+	indirect := false
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			if i := slices.IndexFunc(own, func(c ruleCall) bool { return c.name == "required" }); i >= 0 {
+				vd.errs = append(vd.errs, &FieldError{Key: key, Rule: "required", Param: own[i].param, Err: errRequired})
+			}
+			return nil
+		}
+		v = v.Elem()
+		indirect = true
+	}
+
+	for _, c := range own {
+		if c.name == "omitempty" || (c.name == "required" && indirect) {
+			continue
+		}
+		rule, ok := lookupRule(c.name)
+		if !ok {
+			return fmt.Errorf("paramserializer: unknown validation rule %q on %s", c.name, key)
+		}
+		if err := rule(FieldValue{Key: key, Value: v, Param: c.param, Struct: parent}); err != nil {
+			// Only the first rule a value breaks is reported.
+			vd.errs = append(vd.errs, &FieldError{Key: key, Rule: c.name, Param: c.param, Err: err})
+			return nil
+		}
+	}
+
+	if dive < 0 {
+		return vd.descend(v, key)
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := vd.validateField(v.Index(i), subKey(key, strconv.Itoa(i)), elems, parent); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		entries, err := sortedEntries(v)
+		if err != nil {
+			return fmt.Errorf("paramserializer: validating %s: %w", key, err)
+		}
+		for _, en := range entries {
+			if err := vd.validateField(en.value, subKey(key, en.name), elems, parent); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("paramserializer: dive on %s of type %s, which has no elements", key, v.Type())
+	}
+	return nil
+}
+
+// descend validates the structs in v: v itself, or the elements of a slice,
+// array or map.
+func (vd *validator) descend(v reflect.Value, key string) error {
+	if isScalar(v.Type()) {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return vd.validateStruct(v, key)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := vd.validateField(v.Index(i), subKey(key, strconv.Itoa(i)), nil, reflect.Value{}); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		entries, err := sortedEntries(v)
+		if err != nil {
+			return fmt.Errorf("paramserializer: validating %s: %w", key, err)
+		}
+		for _, en := range entries {
+			if err := vd.validateField(en.value, subKey(key, en.name), nil, reflect.Value{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// joinKey returns the query key rel, relative to key, as a key of its own:
+// "end" under "period" is "period[end]".
+func joinKey(key, rel string) string {
+	if key == "" {
+		return rel
+	}
+	if rel == "" {
+		return key
+	}
+	name, rest, nested := strings.Cut(rel, "[")
+	if !nested {
+		return subKey(key, name)
+	}
+	return subKey(key, name) + "[" + rest
+}
+
+var errRequired = errors.New("is required")
+
+func required(fv FieldValue) error {
+	v := fv.Value
+	var missing bool
+	switch v.Kind() {
+	case reflect.String:
+		missing = strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		missing = v.Len() == 0
+	default:
+		missing = v.IsZero()
+	}
+	if missing {
+		return errRequired
+	}
+	return nil
+}
+
+// boundRule returns a rule comparing the size of a value, as by size, to
+// its parameter with ok. bound words the comparison in errors.
+func boundRule(bound string, ok func(got, limit float64) bool) Rule {
+	return func(fv FieldValue) error {
+		got, limit, length, err := size(fv.Value, fv.Param)
+		if err != nil {
+			return err
+		}
+		if ok(got, limit) {
+			return nil
+		}
+		if length {
+			return fmt.Errorf("must have a length of %s%s", bound, fv.Param)
+		}
+		return fmt.Errorf("must be %s%s", bound, fv.Param)
+	}
+}
+
+// size returns the value of a number, or the length of a string, slice,
+// array or map, with the limit param sets on it. length reports whether it
+// is a length.
+func size(v reflect.Value, param string) (got, limit float64, length bool, err error) {
+	switch v.Kind() {
+	case reflect.String:
+		got, length = float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		got, length = float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(param)
+			if err != nil {
+				return 0, 0, false, fmt.Errorf("invalid duration %q in rule", param)
+			}
+			return float64(v.Int()), float64(d), false, nil
+		}
+		got = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		got = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		got = v.Float()
+	default:
+		return 0, 0, false, fmt.Errorf("has type %s, which has no size", v.Type())
+	}
+	limit, err = strconv.ParseFloat(param, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid number %q in rule", param)
+	}
+	return got, limit, length, nil
+}
+
+func email(fv FieldValue) error {
+	if fv.Value.Kind() != reflect.String {
+		return fmt.Errorf("has type %s, not a string", fv.Value.Type())
+	}
+	s := fv.Value.String()
+	if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+		return errors.New("must be an email address")
+	}
+	return nil
+}
+
+func oneOf(fv FieldValue) error {
+	s, err := formatScalar(fv.Value)
+	if err != nil {
+		return err
+	}
+	allowed := strings.Fields(fv.Param)
+	if !slices.Contains(allowed, s) {
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// fieldRule returns a rule comparing a value to the field its parameter
+// names, as by compare, with ok. verb words the comparison in errors.
+// Values that cannot be ordered can still be compared for equality unless
+// ordered is set.
+func fieldRule(verb string, ordered bool, ok func(c int) bool) Rule {
+	return func(fv FieldValue) error {
+		sf, found := fv.Struct.Type().FieldByName(fv.Param)
+		if !found {
+			return fmt.Errorf("has no field %s to compare with", fv.Param)
+		}
+		other, set := fieldByIndexNoAlloc(fv.Struct, sf.Index)
+		if !set {
+			return nil
+		}
+		for other.Kind() == reflect.Pointer || other.Kind() == reflect.Interface {
+			if other.IsNil() {
+				return nil
+			}
+			other = other.Elem()
+		}
+		c, comparable := compare(fv.Value, other)
+		if !comparable {
+			if ordered || fv.Value.Type() != other.Type() {
+				return fmt.Errorf("cannot be compared with %s of type %s", fv.Param, other.Type())
+			}
+			if reflect.DeepEqual(fv.Value.Interface(), other.Interface()) {
+				c = 0
+			} else {
+				c = 1
+			}
+		}
+		if !ok(c) {
+			return fmt.Errorf("must %s %s", verb, fieldKey(fv.Struct.Type(), sf))
+		}
+		return nil
+	}
+}
+
+// fieldKey returns the query key of the field sf of struct type t, or its
+// Go name if it has none.
+func fieldKey(t reflect.Type, sf reflect.StructField) string {
+	for _, f := range cachedFields(t) {
+		if slices.Equal(f.index, sf.Index) {
+			return f.name
+		}
+	}
+	return sf.Name
+}
+
+// compare compares two values of the same ordered type, including
+// time.Time. It reports false for values it cannot order.
+func compare(a, b reflect.Value) (int, bool) {
+	if a.Type() != b.Type() {
+		return 0, false
+	}
+	if a.Type() == timeType {
+		return a.Interface().(time.Time).Compare(b.Interface().(time.Time)), true
+	}
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(a.String(), b.String()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float()), true
+	}
+	return 0, false
+}
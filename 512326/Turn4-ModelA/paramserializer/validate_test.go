@@ -0,0 +1,348 @@
+package paramserializer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fieldErrors returns the "key rule" pairs of the ValidationErrors err, or
+// fails t if err is some other error.
+func fieldErrors(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Key+" "+e.Rule)
+	}
+	return got
+}
+
+func TestValidateRules(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		v    any
+		want []string
+	}{
+		{"required string", struct {
+			A string `validate:"required"`
+		}{"x"}, nil},
+		{"required blank string", struct {
+			A string `validate:"required"`
+		}{" \t"}, []string{"a required"}},
+		{"required int", struct {
+			A int `validate:"required"`
+		}{0}, []string{"a required"}},
+		{"required slice", struct {
+			A []int `validate:"required"`
+		}{[]int{}}, []string{"a required"}},
+		{"required map", struct {
+			A map[string]int `validate:"required"`
+		}{map[string]int{}}, []string{"a required"}},
+		{"required pointer", struct {
+			A *int `validate:"required"`
+		}{}, []string{"a required"}},
+		{"required pointer to zero", struct {
+			A *int `validate:"required"`
+		}{ptr(0)}, nil},
+		{"nil pointer breaks only required", struct {
+			A *int `validate:"min=1"`
+		}{}, nil},
+		{"min int", struct {
+			A int `validate:"min=1"`
+		}{0}, []string{"a min"}},
+		{"max float", struct {
+			A float64 `validate:"max=1.5"`
+		}{1.6}, []string{"a max"}},
+		{"max uint", struct {
+			A uint `validate:"max=2"`
+		}{2}, nil},
+		{"min string counts runes", struct {
+			A string `validate:"min=3"`
+		}{"né"}, []string{"a min"}},
+		{"max slice", struct {
+			A []int `validate:"max=1"`
+		}{[]int{1, 2}}, []string{"a max"}},
+		{"len map", struct {
+			A map[string]int `validate:"len=1"`
+		}{map[string]int{"x": 1}}, nil},
+		{"len string", struct {
+			A string `validate:"len=2"`
+		}{"abc"}, []string{"a len"}},
+		{"max duration", struct {
+			A time.Duration `validate:"max=1h"`
+		}{2 * time.Hour}, []string{"a max"}},
+		{"min pointer", struct {
+			A *int `validate:"min=1"`
+		}{ptr(0)}, []string{"a min"}},
+		{"email", struct {
+			A string `validate:"email"`
+		}{"jane@example.com"}, nil},
+		{"email with name", struct {
+			A string `validate:"email"`
+		}{"Jane <jane@example.com>"}, []string{"a email"}},
+		{"email invalid", struct {
+			A string `validate:"email"`
+		}{"jane"}, []string{"a email"}},
+		{"oneof string", struct {
+			A string `validate:"oneof=red green"`
+		}{"green"}, nil},
+		{"oneof int", struct {
+			A int `validate:"oneof=1 2"`
+		}{3}, []string{"a oneof"}},
+		{"omitempty", struct {
+			A string `validate:"omitempty,email"`
+		}{""}, nil},
+		{"omitempty set", struct {
+			A string `validate:"omitempty,email"`
+		}{"x"}, []string{"a email"}},
+		{"first broken rule only", struct {
+			A string `validate:"required,min=2"`
+		}{""}, []string{"a required"}},
+		{"query key", struct {
+			A string `query:"alpha" validate:"required"`
+		}{}, []string{"alpha required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fieldErrors(t, Validate(tt.v))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("cross-field", func(t *testing.T) {
+		type period struct {
+			Start  time.Time `validate:"ltfield=End"`
+			End    time.Time `query:"until"`
+			Min    int       `validate:"ltefield=Max"`
+			Max    int
+			Pass   string `validate:"eqfield=Repeat"`
+			Repeat string
+			Old    string `validate:"nefield=New"`
+			New    string
+			Hi     float64 `validate:"gtfield=Lo"`
+			Lo     float64
+			Top    uint `validate:"gtefield=Bottom"`
+			Bottom uint
+		}
+		valid := period{Start: t0, End: t0.Add(time.Hour), Min: 1, Max: 1, Pass: "a", Repeat: "a", Old: "a", New: "b", Hi: 2, Lo: 1, Top: 1, Bottom: 1}
+		if err := Validate(valid); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		invalid := period{Start: t0, End: t0, Min: 2, Max: 1, Pass: "a", Repeat: "b", Old: "a", New: "a", Hi: 1, Lo: 1, Top: 0, Bottom: 1}
+		err := Validate(invalid)
+		want := []string{"start ltfield", "min ltefield", "pass eqfield", "old nefield", "hi gtfield", "top gtefield"}
+		if got := fieldErrors(t, err); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+		// The other field is named by its query key.
+		if !strings.Contains(err.Error(), "start: must be less than until") {
+			t.Errorf("Expected the error to name until, got %v", err)
+		}
+	})
+
+	t.Run("cross-field unordered", func(t *testing.T) {
+		type pair struct {
+			A []int `validate:"eqfield=B"`
+			B []int
+			C []int `validate:"gtfield=D"`
+			D []int
+		}
+		err := Validate(pair{A: []int{1}, B: []int{2}, C: []int{1}, D: []int{1}})
+		if got, want := fieldErrors(t, err), []string{"a eqfield", "c gtfield"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("dive", func(t *testing.T) {
+		type list struct {
+			Tags   []string          `validate:"min=1,dive,required,max=3"`
+			Scores map[string]int    `validate:"dive,min=0"`
+			Items  []item            `validate:"dive"`
+			Empty  []string          `validate:"min=1,dive,required"`
+			Nested map[string][]item `validate:"dive,max=1"`
+		}
+		err := Validate(list{
+			Tags:   []string{"go", " ", "toolong"},
+			Scores: map[string]int{"b": -1, "a": 1, "c": -2},
+			Nested: map[string][]item{"x": {{}, {}}},
+		})
+		want := []string{"tags[1] required", "tags[2] max", "scores[b] min", "scores[c] min", "empty min", "nested[x] max"}
+		if got := fieldErrors(t, err); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	})
+}
+
+func TestValidateNested(t *testing.T) {
+	type order struct {
+		Items    []validItem          `query:"items"`
+		Shipping *Address             `query:"shipping"`
+		Gifts    map[string]validItem `query:"gifts"`
+	}
+	err := Validate(&order{
+		Items:    []validItem{{Name: "a", Qty: 1}, {Qty: 0}},
+		Shipping: &Address{City: "Paris"},
+		Gifts:    map[string]validItem{"k": {Name: "b", Qty: 1}},
+	})
+	want := []string{"items[1][name] required", "items[1][qty] min", "shipping[state] required"}
+	if got := fieldErrors(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+type validItem struct {
+	Name string `validate:"required"`
+	Qty  int    `validate:"min=1"`
+}
+
+type dateRange struct {
+	From time.Time
+	To   time.Time `query:"to"`
+}
+
+func (r *dateRange) Validate() error {
+	if r.To.Before(r.From) {
+		return ValidationErrors{{Key: "to", Err: errors.New("must not be before from")}}
+	}
+	if r.From.IsZero() {
+		return errors.New("from is required")
+	}
+	return nil
+}
+
+func TestValidateStructValidator(t *testing.T) {
+	type booking struct {
+		Stay  dateRange `query:"stay"`
+		Other dateRange `query:"other"`
+	}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := Validate(booking{Stay: dateRange{From: t0, To: t0.Add(-time.Hour)}, Other: dateRange{}})
+	if got, want := fieldErrors(t, err), []string{"stay[to] ", "other "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestValidationErrorsError(t *testing.T) {
+	type form struct {
+		Name  string `validate:"required"`
+		Age   int    `validate:"min=1,max=120"`
+		Email string `validate:"email"`
+	}
+	err := Validate(form{Age: 130, Email: "x"})
+	want := "name: is required; age: must be at most 120; email: must be an email address"
+	if err == nil || err.Error() != want {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("Expected 3 ValidationErrors, got %v", err)
+	}
+	if fe := errs[0]; fe.Key != "name" || fe.Rule != "required" || !errors.Is(fe, errRequired) {
+		t.Errorf("Expected the first error to be for name, got %+v", fe)
+	}
+	if fe := errs[1]; fe.Key != "age" || fe.Rule != "max" || fe.Param != "120" {
+		t.Errorf("Expected the second error to be age max=120, got %+v", fe)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	type unknown struct {
+		A string `validate:"nosuchrule"`
+	}
+	type badDive struct {
+		A int `validate:"dive,required"`
+	}
+	for _, v := range []any{unknown{}, badDive{}, 1, nil} {
+		err := Validate(v)
+		var errs ValidationErrors
+		if err == nil || errors.As(err, &errs) {
+			t.Errorf("Validate(%T): expected an error other than ValidationErrors, got %v", v, err)
+		}
+	}
+}
+
+var registerEven sync.Once
+
+func TestRegisterRule(t *testing.T) {
+	registerEven.Do(func() {
+		RegisterRule("even", func(fv FieldValue) error {
+			if fv.Value.Int()%2 != 0 {
+				return fmt.Errorf("must be even, under %s", fv.Param)
+			}
+			return nil
+		})
+	})
+	type numbers struct {
+		A int   `validate:"even=a"`
+		B []int `validate:"dive,even"`
+	}
+	err := Validate(numbers{A: 1, B: []int{2, 3}})
+	if got, want := fieldErrors(t, err), []string{"a even", "b[1] even"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if !strings.Contains(err.Error(), "a: must be even, under a") {
+		t.Errorf("Expected the rule to get its parameter, got %v", err)
+	}
+
+	for _, name := range []string{"even", "required", "", "a,b", "a=b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterRule(%q): expected a panic", name)
+				}
+			}()
+			RegisterRule(name, func(FieldValue) error { return nil })
+		}()
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	valid := func() *User {
+		return &User{Name: "Jane", Age: 30, Tags: []string{" go ", "api"}, Address: Address{City: "Paris", State: "IDF"}}
+	}
+	u := valid()
+	if err := ValidateUser(u); err != nil {
+		t.Fatalf("Expected a valid user, got %v", err)
+	}
+	if want := []string{"go", "api"}; !reflect.DeepEqual(u.Tags, want) {
+		t.Errorf("Expected the tags to be trimmed to %q, got %q", want, u.Tags)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*User)
+		want   []string
+	}{
+		{"blank name", func(u *User) { u.Name = "  " }, []string{"name required"}},
+		{"zero age", func(u *User) { u.Age = 0 }, []string{"age min"}},
+		{"large age", func(u *User) { u.Age = 150 }, nil},
+		{"whitespace tag", func(u *User) { u.Tags = []string{"go", " \t "} }, []string{"tags[1] required"}},
+		{"no tags", func(u *User) { u.Tags = nil }, nil},
+		{"blank address", func(u *User) { u.Address = Address{City: " "} }, []string{"address[city] required", "address[state] required"}},
+		{"all at once", func(u *User) { *u = User{Tags: []string{""}} },
+			[]string{"name required", "age min", "tags[0] required", "address[city] required", "address[state] required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := valid()
+			tt.modify(u)
+			if got := fieldErrors(t, ValidateUser(u)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}